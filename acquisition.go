@@ -0,0 +1,57 @@
+package dlock
+
+import (
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// Acquisition describes a single tenure of the lock
+type Acquisition struct {
+	Key          string
+	SessionID    string
+	AcquiredAt   time.Time
+	FencingToken uint64 // ModifyIndex of the lock key when acquired, increases with every write to the key
+	Epoch        int    // number of acquisitions by this instance, this one included
+	First        bool   // first acquisition by this instance, e.g. to run a full cold init only once
+}
+
+// RetryLockAcquisition is `RetryLockAcquire` which sends the Acquisition to `acquired` instead of a bare msg
+// `released` receives msg only after the Acquisition was delivered. nothing is sent on either chan
+// when the attempts give up, the fatal error is sent to `Errors()` as for RetryLockAcquire
+func (d *Dlock) RetryLockAcquisition(value map[string]string, acquired chan<- Acquisition, released chan<- bool) {
+	ch := make(chan bool, 1)
+	rel := make(chan bool, 1)
+	d.RetryLockAcquire(value, ch, rel)
+	select {
+	case <-ch:
+		acquired <- d.LastAcquisition()
+		go func() {
+			released <- <-rel
+		}()
+	default:
+	}
+}
+
+// LastAcquisition returns the most recent acquisition by this instance, zero if the lock was never acquired
+// it is kept after the release, see `IsLeader` for whether the lock is still held
+func (d *Dlock) LastAcquisition() Acquisition {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.acquisition
+}
+
+// recordAcquisition notes the acquisition of key under sessionID in the current epoch
+// the fencing token is read back from the key, it is left zero if the read fails
+func (d *Dlock) recordAcquisition(key string, sessionID string, q *api.QueryOptions) {
+	var token uint64
+	pair, _, err := d.ConsulClient.KV().Get(key, q)
+	if err != nil {
+		d.logger().Println("error on reading fencing token :", err)
+	} else if pair != nil {
+		token = pair.ModifyIndex
+	}
+	d.mu.Lock()
+	d.acquisition = Acquisition{Key: key, SessionID: sessionID, AcquiredAt: d.acquiredAt, FencingToken: token, Epoch: d.epoch, First: d.epoch == 1}
+	d.mu.Unlock()
+}
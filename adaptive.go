@@ -0,0 +1,66 @@
+package dlock
+
+import (
+	"context"
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// adaptiveMaxFactor bounds the wait of `AdaptiveRetry` to this many retry intervals unless `AdaptiveMaxInterval` is set
+const adaptiveMaxFactor = 8
+
+// adaptiveDelay returns the wait of a follower in `AdaptiveRetry` after losing followed attempts in a row
+// base is doubled with every attempt up to `AdaptiveMaxInterval`
+func (d *Dlock) adaptiveDelay(base time.Duration, followed int) time.Duration {
+	max := d.AdaptiveMaxInterval
+	if max <= 0 {
+		max = adaptiveMaxFactor * base
+	}
+	return ExponentialBackoff(base, max)(followed)
+}
+
+// waitAdaptive is `wait` which is also cut short once the lock key becomes unheld, watched through blocking queries
+// freed is true if the wait ended because the key became unheld
+func (d *Dlock) waitAdaptive(ctx context.Context, delay time.Duration) (ok bool, freed bool) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	free := make(chan struct{})
+	go d.watchUnheld(watchCtx, delay, free)
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true, false
+	case <-d.configCh:
+		return true, false
+	case <-free:
+		d.logger().Println("lock key became unheld, attempting right away")
+		return true, true
+	case <-ctx.Done():
+		return false, false
+	}
+}
+
+// watchUnheld closes free once the lock key goes from held to unheld, a key already unheld is not reported
+// so an attempt refused meanwhile, e.g. within the lock-delay, does not turn into a busy loop
+// on error it gives up and leaves the wait to its timer
+func (d *Dlock) watchUnheld(ctx context.Context, maxWait time.Duration, free chan<- struct{}) {
+	q := (&api.QueryOptions{WaitTime: maxWait}).WithContext(ctx)
+	for {
+		pair, meta, err := d.ConsulClient.KV().Get(d.Key, q)
+		if err != nil {
+			return
+		}
+		if q.WaitIndex != 0 && (pair == nil || pair.Session == "") {
+			close(free)
+			return
+		}
+		if meta.LastIndex < q.WaitIndex {
+			// index went backwards e.g. after a snapshot restore
+			q.WaitIndex = 0
+			continue
+		}
+		q.WaitIndex = meta.LastIndex
+	}
+}
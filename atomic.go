@@ -0,0 +1,32 @@
+package dlock
+
+import (
+	"context"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// acquireTxn locks key under sessionID with the holder value b in a single transaction, which also
+// writes b to `StatusKey` and verifies that `ParentKey` is held by sessionID, if they are set
+// returns false if the transaction was refused e.g. the key is held by another session or within its lock-delay
+// the api.Lock then finds the key already held by sessionID and only starts monitoring it
+func (d *Dlock) acquireTxn(ctx context.Context, key string, b []byte, sessionID string) (bool, error) {
+	var ops api.TxnOps
+	if d.ParentKey != "" {
+		ops = append(ops, &api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVCheckSession, Key: d.ParentKey, Session: sessionID}})
+	}
+	ops = append(ops, &api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVLock, Key: key, Value: b, Flags: api.LockFlagValue, Session: sessionID}})
+	if d.StatusKey != "" {
+		ops = append(ops, &api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVSet, Key: d.StatusKey, Value: b}})
+	}
+	ok, resp, _, err := d.ConsulClient.Txn().Txn(ops, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	if !ok && resp != nil {
+		for _, e := range resp.Errors {
+			d.logger().Println("lock transaction refused :", e.What)
+		}
+	}
+	return ok, nil
+}
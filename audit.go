@@ -0,0 +1,91 @@
+package dlock
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// historyPrefix is appended to the lock key to build the audit trail prefix
+const historyPrefix = "/history/"
+
+// AuditEntry is a leadership transition recorded in the audit trail
+type AuditEntry struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Event     string            `json:"event"` // `acquired` or `released`
+	Key       string            `json:"key"`
+	SessionID string            `json:"session"`
+	Holder    map[string]string `json:"holder"`
+}
+
+// audit records a leadership transition in background, to `AuditSink` if set, else under `key/history/`
+// entries are recorded one at a time in the order of the transitions
+func (d *Dlock) audit(event string, key string, sessionID string, holder map[string]string) {
+	if !d.AuditHistory && d.AuditSink == nil {
+		return
+	}
+	e := AuditEntry{Timestamp: time.Now(), Event: event, Key: key, SessionID: sessionID, Holder: holder}
+	d.auditMu.Lock()
+	defer d.auditMu.Unlock()
+	d.auditQueue = append(d.auditQueue, e)
+	if d.auditing {
+		return
+	}
+	d.auditing = true
+	go d.drainAudit()
+}
+
+// drainAudit records the queued audit entries in order and returns once the queue is empty
+func (d *Dlock) drainAudit() {
+	for {
+		d.auditMu.Lock()
+		if len(d.auditQueue) == 0 {
+			d.auditing = false
+			d.auditMu.Unlock()
+			return
+		}
+		e := d.auditQueue[0]
+		d.auditQueue = d.auditQueue[1:]
+		d.auditMu.Unlock()
+		d.recordAudit(e)
+	}
+}
+
+// recordAudit records a single entry
+func (d *Dlock) recordAudit(e AuditEntry) {
+	if d.AuditSink != nil {
+		d.AuditSink(e)
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		d.logger().Println("error on audit entry marshal", err)
+		return
+	}
+	// zero padded timestamp keeps entries listed in chronological order
+	k := fmt.Sprintf("%s%s%020d-%s", e.Key, historyPrefix, e.Timestamp.UnixNano(), e.SessionID)
+	if _, err := d.ConsulClient.KV().Put(&api.KVPair{Key: k, Value: b}, nil); err != nil {
+		d.logger().Println("error on writing audit entry :", err)
+	}
+}
+
+// History returns the audit trail recorded under `Key/history/` in chronological order
+// entries are only recorded by instances with `AuditHistory` set
+func (d *Dlock) History() ([]AuditEntry, error) {
+	pairs, _, err := d.ConsulClient.KV().List(d.Key+historyPrefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, 0, len(pairs))
+	for _, p := range pairs {
+		var e AuditEntry
+		if err := json.Unmarshal(p.Value, &e); err != nil {
+			d.logger().Println("skipping unreadable audit entry", p.Key, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
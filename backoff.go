@@ -0,0 +1,110 @@
+package dlock
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff returns the wait after the given failed attempt, attempts start at 1
+type Backoff func(attempt int) time.Duration
+
+// ConstantBackoff waits delay after every attempt
+func ConstantBackoff(delay time.Duration) Backoff {
+	return func(int) time.Duration {
+		return delay
+	}
+}
+
+// ExponentialBackoff waits base after the first attempt and doubles the wait after every further attempt, up to max
+func ExponentialBackoff(base time.Duration, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		delay := base
+		for i := 1; i < attempt && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			delay = max
+		}
+		return delay
+	}
+}
+
+// DecorrelatedJitterBackoff waits a random duration between base and 3 times the previous wait, up to max
+// waits of a fleet of contenders drift apart instead of hitting consul in lockstep. base must be positive
+// a max below base caps every wait at max. the previous wait is kept by the returned Backoff and reset on attempt 1, use one per retry loop
+func DecorrelatedJitterBackoff(base time.Duration, max time.Duration) Backoff {
+	var mu sync.Mutex
+	prev := base
+	return func(attempt int) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		if attempt <= 1 {
+			prev = base
+		}
+		upper := prev * 3
+		if upper < base {
+			// prev was capped well below base by max
+			upper = base
+		}
+		delay := base + time.Duration(rand.Int63n(int64(upper-base)+1))
+		if delay > max {
+			delay = max
+		}
+		prev = delay
+		return delay
+	}
+}
+
+// TryAcquireN makes up to maxAttempts attempts to acquire the lock, waiting as given by backoff in between
+// an attempt skipped as by `RetryLockAcquire` (open breaker, suspension, closed schedule, `ReadyFunc`) counts as made
+// returns whether the lock was acquired and the chan which receives msg once it is released
+// err is the error of the last attempt if it failed, a fatal error (see `IsFatal`) which stopped the attempts
+// or ctx.Err() once ctx is done, ctx also aborts the consul calls of an attempt
+func (d *Dlock) TryAcquireN(ctx context.Context, value map[string]string, maxAttempts int, backoff Backoff) (acquired bool, released <-chan bool, err error) {
+	if d.permanentlyReleased() {
+		return false, nil, ErrPermanentlyReleased
+	}
+	d.mu.Lock()
+	held := d.lock != nil
+	d.mu.Unlock()
+	if held {
+		return false, nil, ErrAlreadyHeld
+	}
+	releasedCh := make(chan bool, 1)
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return false, nil, err
+		}
+		// the Dlock may be destroyed while waiting between attempts
+		if d.permanentlyReleased() {
+			return false, nil, ErrPermanentlyReleased
+		}
+		var lock bool
+		var err error
+		if d.attemptAllowed() {
+			lock, err = d.acquireLock(ctx, d.holderValue(value), releasedCh)
+			d.mu.Lock()
+			d.breaker.record(err, time.Now())
+			d.mu.Unlock()
+		}
+		if lock {
+			d.logger().Printf("lock acquired with consul session - %s after %d attempts", d.sessionID(), attempt)
+			return true, releasedCh, nil
+		}
+		if err != nil {
+			d.logger().Println("error on acquireLock :", err)
+			if IsFatal(err) {
+				return false, nil, err
+			}
+		}
+		if attempt == maxAttempts {
+			return false, nil, err
+		}
+		if !sleepCtx(ctx, backoff(attempt)) {
+			return false, nil, ctx.Err()
+		}
+	}
+	return false, nil, nil
+}
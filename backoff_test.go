@@ -0,0 +1,65 @@
+package dlock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sameervitian/dlock/internal/fakeconsul"
+)
+
+func TestDecorrelatedJitterBackoffBounds(t *testing.T) {
+	cases := []struct {
+		base time.Duration
+		max  time.Duration
+	}{
+		{time.Second, time.Minute},
+		{time.Minute, 10 * time.Second},
+		{time.Second, time.Second},
+	}
+	for _, c := range cases {
+		backoff := DecorrelatedJitterBackoff(c.base, c.max)
+		for attempt := 1; attempt <= 50; attempt++ {
+			delay := backoff(attempt)
+			if delay > c.max {
+				t.Fatalf("base %s max %s attempt %d: delay %s above max", c.base, c.max, attempt, delay)
+			}
+			if delay < c.base && delay != c.max {
+				t.Fatalf("base %s max %s attempt %d: delay %s below base", c.base, c.max, attempt, delay)
+			}
+		}
+	}
+}
+
+func TestTryAcquireNChecksReadiness(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	ready := false
+	d := newTestDlock(t, srv, "test/try-acquire-n", Config{ReadyFunc: func() bool { return ready }})
+	defer d.DestroySession()
+	acquired, _, err := d.TryAcquireN(context.Background(), nil, 3, ConstantBackoff(10*time.Millisecond))
+	if acquired || err != nil {
+		t.Fatalf("TryAcquireN while not ready = %v, %v", acquired, err)
+	}
+	if d.sessionID() != "" {
+		t.Error("session created while not ready")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err = d.TryAcquireN(ctx, nil, 3, ConstantBackoff(time.Minute))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("TryAcquireN on done ctx returned %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("backoff not cut short by ctx")
+	}
+
+	ready = true
+	acquired, _, err = d.TryAcquireN(context.Background(), nil, 3, ConstantBackoff(10*time.Millisecond))
+	if !acquired || err != nil {
+		t.Fatalf("TryAcquireN once ready = %v, %v", acquired, err)
+	}
+}
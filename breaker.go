@@ -0,0 +1,63 @@
+package dlock
+
+import "time"
+
+// BreakerState is the state of the circuit breaker around consul calls
+type BreakerState int
+
+const (
+	// BreakerClosed lets every acquisition attempt through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen skips acquisition attempts until the cool-down is over
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe attempt through after the cool-down
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// breaker opens after `threshold` consecutive failures and stays open for `cooldown`
+// zero threshold disables it
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+func (b *breaker) state(now time.Time) BreakerState {
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return BreakerClosed
+	}
+	if now.Before(b.openUntil) {
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
+
+// allow reports whether an attempt can be made now
+func (b *breaker) allow(now time.Time) bool {
+	return b.state(now) != BreakerOpen
+}
+
+// record accounts the outcome of an attempt
+func (b *breaker) record(err error, now time.Time) {
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
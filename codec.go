@@ -0,0 +1,22 @@
+package dlock
+
+import "encoding/json"
+
+// Codec encodes the holder value stored in the lock key
+type Codec interface {
+	Marshal(v map[string]string) ([]byte, error)
+	Unmarshal(b []byte, v *map[string]string) error
+}
+
+// JSONCodec is the default Codec
+type JSONCodec struct{}
+
+// Marshal encodes v as JSON
+func (JSONCodec) Marshal(v map[string]string) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON b into v
+func (JSONCodec) Unmarshal(b []byte, v *map[string]string) error {
+	return json.Unmarshal(b, v)
+}
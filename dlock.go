@@ -1,9 +1,18 @@
 package dlock
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	api "github.com/hashicorp/consul/api"
@@ -14,6 +23,28 @@ const (
 	DefaultLockRetryInterval = 30 * time.Second
 	// DefautSessionTTL is ttl for the session created
 	DefautSessionTTL = 5 * time.Minute
+	// DefaultLogPrefix is the prefix of every dlock log line
+	DefaultLogPrefix = "dlock:"
+	// DefaultLogFlags are the log.Logger flags used for dlock logs
+	DefaultLogFlags = log.Ldate | log.Ltime | log.Lshortfile
+	// DefaultSessionCreateTimeout bounds the creation of a session, checks listing included
+	DefaultSessionCreateTimeout = 5 * time.Second
+	// DefaultServerErrorRetry is the first wait after an attempt failed with a consul 5xx, doubled on every further one
+	DefaultServerErrorRetry = time.Second
+	// DefaultShutdownTimeout bounds the destruction of the session on a signal handled by `HandleSignals`
+	DefaultShutdownTimeout = 10 * time.Second
+	// DefaultMonitorRetries is how many times a consul error is tolerated while monitoring a held lock
+	DefaultMonitorRetries = 3
+	// DefaultMonitorRetryTime is the wait between retries of the lock monitor
+	DefaultMonitorRetryTime = 2 * time.Second
+	// DefaultBreakerCooldown is how long attempts are paused once the circuit breaker opens
+	DefaultBreakerCooldown = 2 * time.Minute
+	// TimeFormatEpoch as `AcquisitionTimeFormat` serializes acquisition time as unix seconds
+	TimeFormatEpoch = "epoch"
+	// TimeFormatEpochMillis as `AcquisitionTimeFormat` serializes acquisition time as unix milliseconds
+	TimeFormatEpochMillis = "epochms"
+	// contendersPrefix is appended to the lock key to build the contender registry prefix
+	contendersPrefix = "/contenders/"
 )
 
 // Dlock configured for lock acquisition
@@ -24,34 +55,393 @@ type Dlock struct {
 	LockRetryInterval time.Duration
 	SessionTTL        time.Duration
 	PermanentRelease  bool
+	ContenderRegistry bool
+	// SelfHandoffCooldown is how long this instance stays away from the lock after a voluntary `Release`
+	SelfHandoffCooldown time.Duration
+	// MaxLeadershipDuration caps a tenure, the lock is voluntarily released once it is reached
+	MaxLeadershipDuration time.Duration
+	// ValueProvider if set is called on every acquisition attempt to build the holder value
+	ValueProvider func() map[string]string
+	// NodeMeta is encoded into the session name to identify the owner of the session
+	NodeMeta map[string]string
+	// StatusKey if set receives a copy of the holder value while the lock is held
+	StatusKey string
+	// AcquisitionTimeFormat is the layout of the injected `lockAcquisitionTime`
+	AcquisitionTimeFormat string
+	// Node is the consul node the session is bound to, empty for the agent's node
+	Node string
+	// RequiredChecks are the only checks attached to the session besides serfHealth, all the agent checks when empty
+	RequiredChecks []string
+	// MonitorRetries is how many times the lock monitor retries on consul errors before releasing
+	MonitorRetries int
+	// MonitorRetryTime is the wait between lock monitor retries
+	MonitorRetryTime time.Duration
+	// LeadershipWebhook if set is notified of every acquisition and release
+	LeadershipWebhook string
+	// Codec encodes the holder value
+	Codec Codec
+	// LockDelay is the consul lock-delay of the sessions created
+	LockDelay time.Duration
+	// LockDelayFunc if set computes the lock-delay of each new session from the previous release reason
+	LockDelayFunc func(previous ReleaseReason) time.Duration
+	// PreAcquireCheck if set validates the existing value of the lock key before each attempt
+	PreAcquireCheck func(existing []byte, flags uint64) error
+	// EventPolicy decides what happens to events when the `Events()` buffer is full
+	EventPolicy DeliveryPolicy
+	// AuditHistory records leadership transitions under `Key/history/`
+	AuditHistory bool
+	// AuditSink if set receives leadership transitions instead of the consul audit trail
+	AuditSink func(AuditEntry)
+	// HeartbeatInterval is the interval at which the holder value is refreshed with a `heartbeat` timestamp
+	HeartbeatInterval time.Duration
+	// OnWaiting if set is called after every attempt which did not acquire the lock
+	OnWaiting func(attempt int, elapsed time.Duration)
+	// OnBecomeLeader if set is called on every acquisition with the leadership epoch, 1 for the first acquisition
+	OnBecomeLeader func(epoch int)
+	// LockOptionsFunc if set can mutate the lock options before every lock attempt
+	LockOptionsFunc func(*api.LockOptions)
+	// ReadyFunc if set gates acquisition, attempts are skipped while it returns false
+	ReadyFunc func() bool
+	// ReusableOnCancel makes context cancellation release the session without permanently releasing the Dlock
+	ReusableOnCancel bool
+	// StaleHolderPolicy tells how to handle the lock held by a previous process on this host
+	StaleHolderPolicy StaleHolderPolicy
+	// Tracer if set traces acquisition attempts, session creation, destruction and renewals
+	Tracer Tracer
+	// OnReleaseValue if set is written to the key on voluntary release so observers do not see a stale holder
+	OnReleaseValue map[string]string
+	// DeleteOnRelease deletes the key on voluntary release
+	DeleteOnRelease bool
+	// StabilityDelay is how long the lock must be held continuously before the acquisition is reported
+	StabilityDelay time.Duration
+	// FallbackChecksOnError creates the session with `FallbackChecks` if the checks cannot be listed
+	FallbackChecksOnError bool
+	// FallbackChecks are the checks of a session created after a failed listing
+	FallbackChecks []string
+	// Renewer if set renews the session instead of a goroutine of this Dlock
+	Renewer *Renewer
+	// OnBeforeDestroy if set is called right before the session is destroyed
+	OnBeforeDestroy func(sessionID string)
+	// SessionCreateTimeout bounds the creation of a session, a hung create is abandoned and retried
+	SessionCreateTimeout time.Duration
+	// ParentKey if set must be held by the session for the lock to be acquired
+	ParentKey string
+	// SignalBuffer is the buffer size of the chans created by `AcquireAsync`
+	SignalBuffer int
+	// TTLCheckID if set is a TTL check the session is gated on instead of a session ttl
+	TTLCheckID string
+	// TTLCheckHeartbeat if set is the interval at which dlock marks `TTLCheckID` passing
+	TTLCheckHeartbeat time.Duration
+	// WaitForHandoff makes each attempt block on consul for up to `LockRetryInterval` for the holder to release
+	WaitForHandoff bool
+	// LeadershipSchedule if set restricts contention and leadership to its daily windows
+	LeadershipSchedule []Window
+	// RetryBackoff if set computes the wait after each failed attempt instead of `LockRetryInterval`
+	RetryBackoff Backoff
+	// IncludeSessionExpiry adds the session ttl and the time it expires unless renewed to the holder value
+	IncludeSessionExpiry bool
+	// SessionFactory if set creates the sessions of this Dlock instead of dlock
+	SessionFactory func() (sessionID string, err error)
+	// ExternalRenewal leaves the renewal of the sessions to their creator, see `SessionFactory`
+	ExternalRenewal bool
+	// SynchronousMode runs no background renewal nor release detection, both happen in `Poll`. meant for tests
+	SynchronousMode bool
+	// ShutdownTimeout bounds the destruction of the session by `HandleSignals`
+	ShutdownTimeout time.Duration
+	// AdaptiveRetry lengthens the wait of a long standing follower and cuts it short once the key becomes unheld
+	AdaptiveRetry bool
+	// AdaptiveMaxInterval bounds the wait of `AdaptiveRetry`
+	AdaptiveMaxInterval time.Duration
+	// AtomicValueSet acquires the lock in a single transaction along with `StatusKey` and the `ParentKey` check
+	AtomicValueSet bool
+	// RenewFactor is the fraction of the session ttl after which the session is renewed
+	RenewFactor float64
+
+	contenderKey string // registry key currently held by this instance, if any
+
+	log        atomic.Value  // *log.Logger of this instance, unset to use the package logger
+	logFormat  string        // LogFormatText or LogFormatJSON
+	jsonLog    *log.Logger   // logger of LogFormatJSON, nil for text
+	logSession atomic.Value  // session id included in JSON logs, readable without d.mu
+	errCh      chan error    // fatal errors which stopped the retry loop
+	events     chan Event    // lifecycle events
+	configCh   chan struct{} // wakes up the retry loop after `UpdateConfig`
+
+	destroyMu     sync.Mutex // serializes DestroySession
+	contenderMu   sync.Mutex // serializes the contender registry writes, guards contenderKey
+	mu            sync.Mutex
+	lock          *api.Lock   // lock currently held, nil when not holding
+	handoffUntil  time.Time   // no re-contention before this time after a voluntary release
+	breaker       breaker     // skips attempts while consul keeps failing
+	churn         churn       // recent session invalidations
+	acquiredAt    time.Time   // start of the current tenure
+	acquisition   Acquisition // most recent acquisition
+	failed        bool        // `FailureSignal` reported this node unhealthy
+	paused        bool        // contention paused through `Pause`
+	state         State
+	subscribers   map[chan StateChange]struct{}
+	leaderTime    time.Duration // cumulative time of the past tenures
+	epoch         int           // number of acquisitions so far
+	renewedAt     time.Time     // last successful renewal or creation of the session
+	renewedTTL    time.Duration // ttl granted at `renewedAt`
+	renewAfter    afterFunc     // times the renewals
+	lastRelease   ReleaseReason // why the lock was last released
+	renewals      atomic.Uint64 // successful session renewals
+	renewFailures atomic.Uint64 // failed session renewals
+	renewBytes    atomic.Uint64 // renewal response bytes
+	keptLock      *api.Lock     // lock released through ReleaseKeepSession, its session stays renewed
+	releasing     *api.Lock     // lock being released voluntarily
+	auditMu       sync.Mutex    // guards auditQueue and auditing, not held while recording
+	auditQueue    []AuditEntry  // audit entries waiting to be recorded in order
+	auditing      bool          // a goroutine is draining auditQueue
+	webhookMu     sync.Mutex    // guards webhookQueue and notifying, not held while posting
+	webhookQueue  [][]byte      // webhook payloads waiting to be posted in order
+	notifying     bool          // a goroutine is draining webhookQueue
+	heldKey       string        // key of the held lock
+	heldValue     *heldValue    // holder value of the held lock
+	handover      *handover     // pending move of the held lock to a new session
+	renewSession  string        // session currently renewed
+	renewDone     chan struct{} // stops the renewal of `renewSession`
+	sessionChecks []string      // checks attached to the last created session
+	service       service       // background lifecycle of `Start` and `Stop`
+	polled        *polledTenure // held lock awaiting release detection by `Poll` in SynchronousMode
+}
+
+// Status is a snapshot of the Dlock state
+type Status struct {
+	Key                 string
+	SessionID           string
+	Held                bool          // lock is currently held by this instance
+	PermanentRelease    bool          // session was destroyed, no further acquisition is possible
+	Breaker             BreakerState  // state of the circuit breaker around consul calls
+	ConsecutiveFailures int           // failed acquisition attempts in a row, contention is not a failure
+	CurrentTenure       time.Duration // time since the current acquisition, zero if not held
+	TotalLeaderTime     time.Duration // cumulative time the lock was held by this instance, current tenure included
+	Epoch               int           // number of acquisitions by this instance, the current one included
+	LastRelease         ReleaseReason // why the lock was last released
 }
 
 // Config is used to configure creation of client
 type Config struct {
-	ConsulKey         string        // key on which lock to acquire
-	LockRetryInterval time.Duration // interval at which attempt is done to acquire lock
-	SessionTTL        time.Duration // time after which consul session will expire and release the lock
+	ConsulKey             string                                     // key on which lock to acquire
+	LockRetryInterval     time.Duration                              // interval at which attempt is done to acquire lock
+	SessionTTL            time.Duration                              // time after which consul session will expire and release the lock. must be longer than LockRetryInterval
+	ContenderRegistry     bool                                       // register under `ConsulKey/contenders/` while contending so ContenderCount can be queried
+	SelfHandoffCooldown   time.Duration                              // time to wait after a voluntary `Release` before contending again, gives peers a chance to take over
+	ValueProvider         func() map[string]string                   // called before each acquisition attempt, its keys are merged over the static value passed to `RetryLockAcquire`
+	NodeMeta              map[string]string                          // encoded into the consul session name as `key[k1=v1,k2=v2]`, visible to operators in the consul UI
+	StatusKey             string                                     // readable KV path mirroring the holder value while the lock is held, removed on release
+	AcquisitionTimeFormat string                                     // time layout of `lockAcquisitionTime` or TimeFormatEpoch/TimeFormatEpochMillis. defaults to time.RFC3339
+	BreakerThreshold      int                                        // consecutive failed attempts after which attempts are paused for `BreakerCooldown`. zero disables the breaker
+	BreakerCooldown       time.Duration                              // time attempts are paused once the breaker opens. defaults to DefaultBreakerCooldown
+	Node                  string                                     // consul node the session is bound to, must be registered in the catalog. defaults to the agent's node
+	LockOptionsFunc       func(*api.LockOptions)                     // called with the lock options right before every lock attempt, allows tuning any of them
+	MonitorRetries        int                                        // retries of the lock monitor on consul errors before reporting the lock as released. defaults to DefaultMonitorRetries, negative disables retries
+	MonitorRetryTime      time.Duration                              // wait between lock monitor retries. defaults to DefaultMonitorRetryTime
+	Logger                *log.Logger                                // logger of this instance. defaults to the package logger set through SetLogger/SetLoggerWriter
+	LeadershipWebhook     string                                     // URL receiving a POST of WebhookEvent on every acquisition and release of the lock
+	OnBecomeLeader        func(epoch int)                            // called on every acquisition, re-acquisitions included. epoch starts at 1 and increases with each acquisition
+	Codec                 Codec                                      // encoding of the holder value written to and read from the lock key. defaults to JSONCodec
+	HeartbeatInterval     time.Duration                              // interval at which a `heartbeat` timestamp is refreshed in the holder value while the lock is held. zero disables it
+	AuditHistory          bool                                       // record every acquisition and release under `ConsulKey/history/`, readable with History
+	AuditSink             func(AuditEntry)                           // receives every acquisition and release instead of the consul audit trail
+	LockDelay             time.Duration                              // consul lock-delay of the sessions, during which the lock cannot be re-acquired after the session holding it is invalidated. zero keeps the consul default (15s)
+	LockDelayFunc         func(previous ReleaseReason) time.Duration // if set, computes the lock-delay of each new session from the reason of the previous release, overriding LockDelay
+	PreAcquireCheck       func(existing []byte, flags uint64) error  // inspects the value and flags already stored in the lock key before each attempt, an error refuses the attempt
+	RequiredChecks        []string                                   // if set, the only checks attached to the session besides serfHealth. by default every check of the agent is attached
+	EventBuffer           int                                        // buffer size of the `Events()` chan. defaults to DefaultEventBuffer
+	EventPolicy           DeliveryPolicy                             // behavior when the `Events()` buffer is full. defaults to DropNewest
+	MaxLeadershipDuration time.Duration                              // lock is voluntarily released after being held this long so leadership rotates. pair with SelfHandoffCooldown. zero holds indefinitely
+	OnWaiting             func(attempt int, elapsed time.Duration)   // called after every attempt which did not acquire the lock, with the attempt number and the time spent waiting so far
+	StabilityWindow       time.Duration                              // window in which repeated session invalidations are considered churn, retries then back off up to the window. zero disables it
+	StabilityThreshold    int                                        // invalidations within StabilityWindow which trigger the backoff. defaults to DefaultStabilityThreshold
+	LogFormat             string                                     // format of the logs of this instance, LogFormatText (default) or LogFormatJSON
+	ReadyFunc             func() bool                                // gates acquisition on the readiness of the application, attempts are skipped while it returns false
+	RenewFactor           float64                                    // fraction of the session ttl after which the session is renewed, within (0, 1). defaults to DefaultRenewFactor. higher values lower renewal traffic but leave less margin for a failed renewal
+	ConsulAddress         string                                     // address of the consul agent e.g. `127.0.0.1:8500`. defaults to CONSUL_HTTP_ADDR or the local agent
+	ReusableOnCancel      bool                                       // on context cancellation `Run`, `LeadWorker` and `AcquireCtx` destroy the session as `ReleaseSession` does, so the Dlock can contend again
+	StaleHolderPolicy     StaleHolderPolicy                          // handling of the lock held by a previous process on this host e.g. after a restart. defaults to StaleHolderContend
+	Tracer                Tracer                                     // traces acquisition attempts, session creation, destruction and renewals e.g. dlockotel.Global(). nil disables tracing
+	OnReleaseValue        map[string]string                          // value written to the key on voluntary release e.g. `{"status": "no-leader"}`. nil leaves the holder value in place
+	DeleteOnRelease       bool                                       // deletes the key on voluntary release instead. cannot be combined with OnReleaseValue
+	StabilityDelay        time.Duration                              // lock must be held continuously for this long before `acquired` and OnBecomeLeader fire. a lock lost meanwhile is never reported
+	FallbackChecksOnError bool                                       // if the checks of the node cannot be listed the session is created with FallbackChecks instead of failing the attempt
+	FallbackChecks        []string                                   // checks attached on fallback. defaults to serfHealth only
+	Renewer               *Renewer                                   // shared renewer of the session, e.g. one for all Dlocks of a process holding many locks. nil renews from a goroutine per held lock
+	OnBeforeDestroy       func(sessionID string)                     // called synchronously right before the session is destroyed, while a held lock is still held e.g. to write a shutdown marker
+	Datacenter            string                                     // datacenter of the lock and its session. defaults to the datacenter of the agent
+	FailureSignal         <-chan bool                                // external failure detector. true releases the lock at once and pauses contention until false is received
+	SessionCreateTimeout  time.Duration                              // bounds the creation of a session so a slow consul does not stall attempts. defaults to DefaultSessionCreateTimeout, negative disables it
+	ParentKey             string                                     // lock key which must be held by the same session before this lock is acquired, for nested leadership. use `AcquireWithSession` with the session of the parent
+	SignalBuffer          int                                        // buffer size of the acquired and released chans created by `AcquireAsync`. defaults to 1
+	TTLCheckID            string                                     // TTL check of the agent the session is gated on, as an alternative to a session ttl renewed by dlock. the session then has no ttl
+	TTLCheckHeartbeat     time.Duration                              // interval at which dlock marks TTLCheckID passing through the agent. zero leaves it to the application
+	ConsulScheme          string                                     // `http` or `https` for requests to consul. defaults to http unless CONSUL_HTTP_SSL is set
+	WaitForHandoff        bool                                       // each attempt blocks on consul for up to LockRetryInterval and acquires the moment the holder releases, instead of polling every LockRetryInterval
+	LeadershipSchedule    []Window                                   // daily windows within which this instance contends. the lock is released voluntarily when the windows close. empty is always
+	RetryBackoff          Backoff                                    // if set, the wait after each failed attempt of a retry loop instead of LockRetryInterval e.g. DecorrelatedJitterBackoff to spread a fleet. attempts restart at 1 with every loop
+	IncludeSessionExpiry  bool                                       // adds `sessionTTL` and `validUntil` to the holder value, validUntil moves forward on every renewal so observers can tell a live leader from a stale entry
+	SessionFactory        func() (sessionID string, err error)       // if set, called for every session instead of creating one, its checks, ttl and behavior are controlled externally. session options of this Config are ignored
+	ExternalRenewal       bool                                       // sessions are not renewed by dlock, e.g. those of SessionFactory renewed by their creator
+	SynchronousMode       bool                                       // test-oriented. a held lock gets no background goroutine: no renewal, heartbeat, rotation nor release watcher. the session is renewed and a release detected only by calls to Poll, pair it with single attempts e.g. TryAcquireN
+	ShutdownTimeout       time.Duration                              // bounds the destruction of the session on a signal handled by HandleSignals. defaults to DefaultShutdownTimeout
+	AdaptiveRetry         bool                                       // adaptive retry strategy: the wait between lost attempts doubles from LockRetryInterval up to AdaptiveMaxInterval, and a blocking query on the key triggers an attempt as soon as it becomes unheld
+	AdaptiveMaxInterval   time.Duration                              // longest wait of AdaptiveRetry. defaults to 8 times LockRetryInterval
+	AtomicValueSet        bool                                       // acquires through one KV transaction which also writes StatusKey and checks ParentKey, so observers never see the lock held without its status
 }
 
+// ErrPermanentlyReleased is returned when the Dlock is used after `DestroySession`
+var ErrPermanentlyReleased = errors.New("dlock: lock is permanently released")
+
+// ErrNotAcquired is returned when an attempt did not acquire the lock because it is held by someone else
+var ErrNotAcquired = errors.New("dlock: lock not acquired")
+
+// ErrAlreadyHeld is returned when acquiring while this Dlock already holds a lock
+var ErrAlreadyHeld = errors.New("dlock: lock already held")
+
+// ErrSessionInvalid is returned when there is no valid consul session
+var ErrSessionInvalid = errors.New("dlock: session is invalid")
+
+// ErrAcquireTimeout is returned when the lock could not be acquired within the given time
+var ErrAcquireTimeout = errors.New("dlock: timed out acquiring lock")
+
+// errPreAcquireCheck wraps the error of `PreAcquireCheck`
+var errPreAcquireCheck = errors.New("dlock: pre-acquire check failed")
+
+// ErrParentNotHeld is returned when `ParentKey` is not held by the session attempting the lock
+var ErrParentNotHeld = errors.New("dlock: parent key not held by session")
+
 var logger *log.Logger
 
 func init() {
-	logger = log.New(os.Stdout, "dlock:", log.Ldate|log.Ltime|log.Lshortfile)
+	logger = log.New(os.Stdout, DefaultLogPrefix, DefaultLogFlags)
 }
 
 // New returns a new Dlock object
 func New(o *Config) (*Dlock, error) {
 	var d Dlock
-	consulClient, err := api.NewClient(api.DefaultConfig())
+	if o.Logger != nil {
+		d.log.Store(o.Logger)
+	}
+	switch o.LogFormat {
+	case "", LogFormatText:
+		d.logFormat = LogFormatText
+	case LogFormatJSON:
+		d.logFormat = LogFormatJSON
+		d.jsonLog = d.newJSONLogger()
+	default:
+		return nil, fmt.Errorf("dlock: unknown log format %q", o.LogFormat)
+	}
+	consulConfig := api.DefaultConfig()
+	if o.ConsulAddress != "" {
+		consulConfig.Address = o.ConsulAddress
+	}
+	if o.Datacenter != "" {
+		consulConfig.Datacenter = o.Datacenter
+	}
+	switch o.ConsulScheme {
+	case "":
+	case "http", "https":
+		consulConfig.Scheme = o.ConsulScheme
+	default:
+		return nil, fmt.Errorf("dlock: unknown consul scheme %q", o.ConsulScheme)
+	}
+	if (consulConfig.Token != "" || consulConfig.TokenFile != "") && consulConfig.Scheme == "http" {
+		d.logger().Printf("WARNING: consul ACL token is configured but requests to - %s use plain http, set ConsulScheme https or CONSUL_HTTP_SSL", consulConfig.Address)
+	}
+	consulClient, err := api.NewClient(consulConfig)
 	if err != nil {
-		logger.Println("error on creating consul client", err)
+		d.logger().Println("error on creating consul client", err)
 		return &d, err
 	}
 
 	d.ConsulClient = consulClient
 	d.Key = o.ConsulKey
+	d.errCh = make(chan error, 1)
+	d.configCh = make(chan struct{}, 1)
+	d.renewAfter = time.After
 	d.LockRetryInterval = DefaultLockRetryInterval
 	d.SessionTTL = DefautSessionTTL
+	d.ContenderRegistry = o.ContenderRegistry
+	d.SelfHandoffCooldown = o.SelfHandoffCooldown
+	d.MaxLeadershipDuration = o.MaxLeadershipDuration
+	d.ValueProvider = o.ValueProvider
+	d.NodeMeta = o.NodeMeta
+	d.StatusKey = o.StatusKey
+	d.AcquisitionTimeFormat = time.RFC3339
+	d.Node = o.Node
+	d.RequiredChecks = o.RequiredChecks
+	d.LockOptionsFunc = o.LockOptionsFunc
+	d.LeadershipWebhook = o.LeadershipWebhook
+	d.OnBecomeLeader = o.OnBecomeLeader
+	d.OnWaiting = o.OnWaiting
+	d.ReadyFunc = o.ReadyFunc
+	d.ReusableOnCancel = o.ReusableOnCancel
+	d.StaleHolderPolicy = o.StaleHolderPolicy
+	d.Tracer = o.Tracer
+	if o.OnReleaseValue != nil && o.DeleteOnRelease {
+		return nil, errors.New("dlock: OnReleaseValue and DeleteOnRelease are mutually exclusive")
+	}
+	d.OnReleaseValue = o.OnReleaseValue
+	d.DeleteOnRelease = o.DeleteOnRelease
+	d.StabilityDelay = o.StabilityDelay
+	d.FallbackChecksOnError = o.FallbackChecksOnError
+	d.Renewer = o.Renewer
+	d.OnBeforeDestroy = o.OnBeforeDestroy
+	d.ParentKey = o.ParentKey
+	d.TTLCheckID = o.TTLCheckID
+	d.WaitForHandoff = o.WaitForHandoff
+	d.LeadershipSchedule = o.LeadershipSchedule
+	d.RetryBackoff = o.RetryBackoff
+	d.IncludeSessionExpiry = o.IncludeSessionExpiry
+	d.SessionFactory = o.SessionFactory
+	d.ExternalRenewal = o.ExternalRenewal
+	d.SynchronousMode = o.SynchronousMode
+	d.AdaptiveRetry = o.AdaptiveRetry
+	d.AdaptiveMaxInterval = o.AdaptiveMaxInterval
+	d.AtomicValueSet = o.AtomicValueSet
+	d.ShutdownTimeout = DefaultShutdownTimeout
+	if o.ShutdownTimeout > 0 {
+		d.ShutdownTimeout = o.ShutdownTimeout
+	}
+	d.TTLCheckHeartbeat = o.TTLCheckHeartbeat
+	d.SignalBuffer = 1
+	if o.SignalBuffer > 0 {
+		d.SignalBuffer = o.SignalBuffer
+	}
+	d.SessionCreateTimeout = DefaultSessionCreateTimeout
+	if o.SessionCreateTimeout != 0 {
+		d.SessionCreateTimeout = o.SessionCreateTimeout
+	}
+	d.FallbackChecks = []string{"serfHealth"}
+	if len(o.FallbackChecks) > 0 {
+		d.FallbackChecks = o.FallbackChecks
+	}
+	d.RenewFactor = DefaultRenewFactor
+	if o.RenewFactor != 0 {
+		if o.RenewFactor < 0 || o.RenewFactor >= 1 {
+			return nil, fmt.Errorf("dlock: renew factor must be within (0, 1), got %v", o.RenewFactor)
+		}
+		d.RenewFactor = o.RenewFactor
+	}
+	d.HeartbeatInterval = o.HeartbeatInterval
+	d.AuditHistory = o.AuditHistory
+	d.AuditSink = o.AuditSink
+	d.LockDelay = o.LockDelay
+	d.LockDelayFunc = o.LockDelayFunc
+	d.PreAcquireCheck = o.PreAcquireCheck
+	d.EventPolicy = o.EventPolicy
+	eventBuffer := DefaultEventBuffer
+	if o.EventBuffer > 0 {
+		eventBuffer = o.EventBuffer
+	}
+	d.events = make(chan Event, eventBuffer)
+	d.Codec = JSONCodec{}
+	if o.Codec != nil {
+		d.Codec = o.Codec
+	}
+	d.MonitorRetries = DefaultMonitorRetries
+	d.MonitorRetryTime = DefaultMonitorRetryTime
 
 	if o.LockRetryInterval != 0 {
 		d.LockRetryInterval = o.LockRetryInterval
@@ -59,56 +449,1172 @@ func New(o *Config) (*Dlock, error) {
 	if o.SessionTTL != 0 {
 		d.SessionTTL = o.SessionTTL
 	}
+	if err := checkTiming(d.SessionTTL, d.LockRetryInterval); err != nil {
+		return nil, err
+	}
+	if o.AcquisitionTimeFormat != "" {
+		d.AcquisitionTimeFormat = o.AcquisitionTimeFormat
+	}
+	if o.MonitorRetries > 0 {
+		d.MonitorRetries = o.MonitorRetries
+	}
+	if o.MonitorRetries < 0 {
+		d.MonitorRetries = 0
+	}
+	if o.MonitorRetryTime != 0 {
+		d.MonitorRetryTime = o.MonitorRetryTime
+	}
+	d.churn = churn{window: o.StabilityWindow, threshold: DefaultStabilityThreshold}
+	if o.StabilityThreshold > 0 {
+		d.churn.threshold = o.StabilityThreshold
+	}
+	d.breaker = breaker{threshold: o.BreakerThreshold, cooldown: DefaultBreakerCooldown}
+	if o.BreakerCooldown != 0 {
+		d.breaker.cooldown = o.BreakerCooldown
+	}
 
+	if o.FailureSignal != nil {
+		go d.watchFailureSignal(o.FailureSignal)
+	}
+	if d.TTLCheckID != "" && d.TTLCheckHeartbeat > 0 {
+		go d.heartbeatTTLCheck()
+	}
+	d.logger().Printf("dlock configured for key - %s retry interval - %s session ttl - %s", d.Key, d.LockRetryInterval, d.SessionTTL)
 	return &d, nil
 }
 
+// EffectiveConfig returns the config in effect, with defaults resolved and runtime updates applied
+func (d *Dlock) EffectiveConfig() Config {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return Config{
+		ConsulKey:             d.Key,
+		LockRetryInterval:     d.LockRetryInterval,
+		SessionTTL:            d.SessionTTL,
+		ContenderRegistry:     d.ContenderRegistry,
+		SelfHandoffCooldown:   d.SelfHandoffCooldown,
+		MaxLeadershipDuration: d.MaxLeadershipDuration,
+		ValueProvider:         d.ValueProvider,
+		NodeMeta:              d.NodeMeta,
+		StatusKey:             d.StatusKey,
+		AcquisitionTimeFormat: d.AcquisitionTimeFormat,
+		BreakerThreshold:      d.breaker.threshold,
+		BreakerCooldown:       d.breaker.cooldown,
+		Node:                  d.Node,
+		LockOptionsFunc:       d.LockOptionsFunc,
+		MonitorRetries:        d.MonitorRetries,
+		MonitorRetryTime:      d.MonitorRetryTime,
+		Logger:                d.baseLogger(),
+		LeadershipWebhook:     d.LeadershipWebhook,
+		OnBecomeLeader:        d.OnBecomeLeader,
+		Codec:                 d.Codec,
+		HeartbeatInterval:     d.HeartbeatInterval,
+		AuditHistory:          d.AuditHistory,
+		AuditSink:             d.AuditSink,
+		LockDelay:             d.LockDelay,
+		LockDelayFunc:         d.LockDelayFunc,
+		PreAcquireCheck:       d.PreAcquireCheck,
+		RequiredChecks:        d.RequiredChecks,
+		EventBuffer:           cap(d.events),
+		EventPolicy:           d.EventPolicy,
+		OnWaiting:             d.OnWaiting,
+		StabilityWindow:       d.churn.window,
+		StabilityThreshold:    d.churn.threshold,
+		LogFormat:             d.logFormat,
+		ReadyFunc:             d.ReadyFunc,
+		RenewFactor:           d.RenewFactor,
+		ReusableOnCancel:      d.ReusableOnCancel,
+		StaleHolderPolicy:     d.StaleHolderPolicy,
+		Tracer:                d.Tracer,
+		OnReleaseValue:        d.OnReleaseValue,
+		DeleteOnRelease:       d.DeleteOnRelease,
+		StabilityDelay:        d.StabilityDelay,
+		FallbackChecksOnError: d.FallbackChecksOnError,
+		FallbackChecks:        d.FallbackChecks,
+		Renewer:               d.Renewer,
+		OnBeforeDestroy:       d.OnBeforeDestroy,
+		SessionCreateTimeout:  d.SessionCreateTimeout,
+		ParentKey:             d.ParentKey,
+		SignalBuffer:          d.SignalBuffer,
+		TTLCheckID:            d.TTLCheckID,
+		TTLCheckHeartbeat:     d.TTLCheckHeartbeat,
+		WaitForHandoff:        d.WaitForHandoff,
+		LeadershipSchedule:    d.LeadershipSchedule,
+		RetryBackoff:          d.RetryBackoff,
+		IncludeSessionExpiry:  d.IncludeSessionExpiry,
+		SessionFactory:        d.SessionFactory,
+		ExternalRenewal:       d.ExternalRenewal,
+		SynchronousMode:       d.SynchronousMode,
+		ShutdownTimeout:       d.ShutdownTimeout,
+		AdaptiveRetry:         d.AdaptiveRetry,
+		AdaptiveMaxInterval:   d.AdaptiveMaxInterval,
+		AtomicValueSet:        d.AtomicValueSet,
+	}
+}
+
 // RetryLockAcquire attempts to acquire the lock at `LockRetryInterval`
 // First consul session is created and then attempt is done to acquire lock on this session
 // Checks configured over Session is all the checks configured for the client itself
 // sends msg to chan `acquired` once lock is acquired
 // msg is sent to `released` chan when the lock is released due to consul session invalidation
+// attempts stop on a fatal error (see `IsFatal`), which is then sent to `Errors()`
+// as is ErrPermanentlyReleased right away if the Dlock was destroyed, no attempt is made then
+// across calls consumers see a strict acquired, released, acquired, released... sequence, one pair per tenure
+// sends block until received, chans with a buffer of at least 1 keep dlock goroutines from waiting on a busy receiver
 func (d *Dlock) RetryLockAcquire(value map[string]string, acquired chan<- bool, released chan<- bool) {
-	if d.PermanentRelease {
-		logger.Printf("lock is permanently released. last session id - %+s", d.SessionID)
+	d.reportError(d.retryLockAcquire(context.Background(), value, acquired, released))
+}
+
+// reportError sends err, if any, to `Errors()` without blocking
+func (d *Dlock) reportError(err error) {
+	if err == nil {
 		return
 	}
-	ticker := time.NewTicker(d.LockRetryInterval)
-	for ; true; <-ticker.C {
-		value["lockAcquisitionTime"] = time.Now().Format(time.RFC3339)
-		lock, err := d.acquireLock(value, released)
-		if err != nil {
-			logger.Println("error on acquireLock :", err, "retry in -", d.LockRetryInterval)
+	select {
+	case d.errCh <- err:
+	default:
+		d.logger().Println("errors chan is full. dropping error :", err)
+	}
+}
+
+// AcquireAsync is `RetryLockAcquire` run in background with chans created by dlock, buffered by `SignalBuffer`
+// acquired receives msg once the lock is acquired and released once that lock is released
+// if the Dlock is permanently released acquired is closed, so a receiver stops waiting
+func (d *Dlock) AcquireAsync(value map[string]string) (acquired <-chan bool, released <-chan bool) {
+	acquiredCh := make(chan bool, d.SignalBuffer)
+	releasedCh := make(chan bool, d.SignalBuffer)
+	go func() {
+		err := d.retryLockAcquire(context.Background(), value, acquiredCh, releasedCh)
+		if errors.Is(err, ErrPermanentlyReleased) {
+			close(acquiredCh)
+		}
+		d.reportError(err)
+	}()
+	return acquiredCh, releasedCh
+}
+
+// Errors returns the chan receiving fatal errors on which `RetryLockAcquire` gave up
+// errors of failed attempts are *AcquireError carrying the Reason of the failure
+func (d *Dlock) Errors() <-chan error {
+	return d.errCh
+}
+
+// retryLockAcquire is RetryLockAcquire which stops retrying once ctx is done
+// returns the fatal error which stopped the attempts, if any
+func (d *Dlock) retryLockAcquire(ctx context.Context, value map[string]string, acquired chan<- bool, released chan<- bool) error {
+	if d.permanentlyReleased() {
+		d.logger().Printf("lock is permanently released. last session id - %+s", d.sessionID())
+		return ErrPermanentlyReleased
+	}
+	d.mu.Lock()
+	if d.lock == nil {
+		d.setStateLocked(StateContending)
+	}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		if d.state == StateContending {
+			d.setStateLocked(StateIdle)
+		}
+		d.mu.Unlock()
+	}()
+	d.mu.Lock()
+	cooldown := time.Until(d.handoffUntil)
+	d.mu.Unlock()
+	if cooldown > 0 {
+		d.logger().Printf("voluntarily released lock recently. contending again in - %s", cooldown)
+		if !d.wait(ctx, cooldown) {
+			return nil
+		}
+	}
+	start := time.Now()
+	attempt := 0
+	serverErrors := 0
+	followed := 0
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		// the Dlock may be destroyed while waiting between attempts
+		if d.permanentlyReleased() {
+			d.logger().Printf("lock is permanently released. last session id - %+s", d.sessionID())
+			return ErrPermanentlyReleased
+		}
+		if !d.attemptAllowed() {
+			if !d.wait(ctx, d.retryInterval()) {
+				return nil
+			}
 			continue
 		}
+		v := d.holderValue(value)
+		tenure := make(chan bool, 1)
+		attemptStart := time.Now()
+		lock, err := d.acquireLock(ctx, v, tenure)
+		attempt++
+		d.mu.Lock()
+		d.breaker.record(err, time.Now())
+		state, cooldown := d.breaker.state(time.Now()), d.breaker.cooldown
+		d.mu.Unlock()
+		if state == BreakerOpen {
+			d.logger().Println("circuit breaker open after consecutive failures. pausing attempts for -", cooldown)
+		}
+		if IsFatal(err) {
+			d.logger().Println("fatal error on acquireLock :", err, "giving up")
+			return err
+		}
+		if err != nil {
+			d.logger().Println("error on acquireLock :", err, "retry in -", d.retryInterval())
+			d.emit(Event{Type: EventAttemptFailed, Key: d.Key, SessionID: d.sessionID(), Err: err}, true)
+		}
+		if err == nil && !lock && d.ContenderRegistry {
+			if err := d.registerContender(v); err != nil {
+				d.logger().Println("error on registering contender :", err)
+			}
+		}
 		if lock {
-			logger.Printf("lock acquired with consul session - %s", d.SessionID)
-			ticker.Stop()
+			d.logger().Printf("lock acquired with consul session - %s", d.sessionID())
+			d.deregisterContender()
 			acquired <- true
-			break
+			// release of this tenure is forwarded only once its acquisition was delivered
+			go func() {
+				released <- <-tenure
+			}()
+			return nil
+		}
+		if d.OnWaiting != nil {
+			d.OnWaiting(attempt, time.Since(start))
+		}
+		delay := d.nextDelay()
+		if d.RetryBackoff != nil {
+			delay = d.RetryBackoff(attempt)
+		}
+		if statusCode(err) >= 500 {
+			// server errors usually clear quickly unlike contention, retry sooner backing off up to the interval
+			serverErrors++
+			delay = ExponentialBackoff(DefaultServerErrorRetry, delay)(serverErrors)
+		} else {
+			serverErrors = 0
 		}
+		if d.AdaptiveRetry && err == nil {
+			// a long standing follower polls less and less, the key becoming unheld cuts the wait short
+			followed++
+			ok, freed := d.waitAdaptive(ctx, d.adaptiveDelay(delay, followed))
+			if !ok {
+				return nil
+			}
+			if freed {
+				followed = 0
+			}
+			continue
+		}
+		if d.WaitForHandoff && err == nil {
+			// the attempt itself blocked on consul for up to the retry interval
+			delay -= time.Since(attemptStart)
+		}
+		if !d.wait(ctx, delay) {
+			return nil
+		}
+	}
+}
+
+// wait blocks for delay or until the config is updated, returns false if ctx is done meanwhile
+// attemptAllowed returns false if the next acquisition attempt is to be skipped
+// because the breaker is open, contention is suspended, the schedule is closed or `ReadyFunc` refused
+func (d *Dlock) attemptAllowed() bool {
+	d.mu.Lock()
+	allow := d.breaker.allow(time.Now())
+	d.mu.Unlock()
+	if !allow || d.suspended() {
+		return false
+	}
+	if _, open := d.scheduleOpen(time.Now()); !open {
+		return false
+	}
+	if d.ReadyFunc != nil && !d.ReadyFunc() {
+		d.logger().Println("not ready to lead, skipping attempt. retry in -", d.retryInterval())
+		return false
+	}
+	return true
+}
+
+func (d *Dlock) wait(ctx context.Context, delay time.Duration) bool {
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-d.configCh:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// checkTiming guards the invariant `SessionTTL` > `LockRetryInterval`
+// otherwise the session of a contender expires between attempts and is re-created on every attempt
+func checkTiming(ttl time.Duration, retryInterval time.Duration) error {
+	if ttl <= retryInterval {
+		return fmt.Errorf("dlock: session ttl %s must be longer than lock retry interval %s", ttl, retryInterval)
+	}
+	return nil
+}
+
+// nextDelay returns the wait before the next attempt, `LockRetryInterval` stretched while sessions churn
+func (d *Dlock) nextDelay() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delay := d.churn.backoff(d.LockRetryInterval, time.Now())
+	if delay != d.LockRetryInterval {
+		d.logger().Printf("session churn detected, backing off for - %s", delay)
+	}
+	return delay
+}
+
+// sessionInvalidated notes an invalidation of the session of this Dlock
+func (d *Dlock) sessionInvalidated() {
+	d.mu.Lock()
+	d.churn.record(time.Now())
+	d.mu.Unlock()
+}
+
+func (d *Dlock) retryInterval() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.LockRetryInterval
+}
+
+// sessionTTL returns the ttl of the current session, which differs from `SessionTTL` once that is updated
+// through UpdateConfig as the new value applies to sessions created afterwards only
+func (d *Dlock) sessionTTL() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.renewedTTL > 0 {
+		return d.renewedTTL
+	}
+	return d.SessionTTL
+}
+
+// Run blocks and manages the whole lock lifecycle: acquire, hold while the session is renewed
+// and re-contend whenever the lock is released. Suited for use with errgroup
+// On ctx cancellation the session is destroyed and the result of `DestroySession` is returned
+// with `ReusableOnCancel` the Dlock is not permanently released and can be run again
+// ErrPermanentlyReleased is returned if the Dlock was already destroyed and a fatal error if acquisition gave up
+func (d *Dlock) Run(ctx context.Context, value map[string]string) error {
+	return d.LeadWorker(ctx, value, nil)
+}
+
+// LeadWorker is `Run` which also starts work in its own goroutine every time the lock is acquired
+// leaderCtx given to work is cancelled as soon as the lock is released (session invalidation, failed check, etc.)
+// or ctx is done. work is expected to return promptly once leaderCtx is done, contention resumes only after it returned
+func (d *Dlock) LeadWorker(ctx context.Context, value map[string]string, work func(leaderCtx context.Context)) error {
+	if d.permanentlyReleased() {
+		return ErrPermanentlyReleased
+	}
+	acquired := make(chan bool, 1)
+	released := make(chan bool, 1)
+	errCh := make(chan error, 1)
+	for {
+		go func() {
+			if err := d.retryLockAcquire(ctx, value, acquired, released); err != nil {
+				errCh <- err
+			}
+		}()
+		select {
+		case <-acquired:
+		case err := <-errCh:
+			return err
+		case <-ctx.Done():
+			return d.releaseOnCancel()
+		}
+		leaderCtx, cancel := context.WithCancel(ctx)
+		workDone := make(chan struct{})
+		go func() {
+			defer close(workDone)
+			if work != nil {
+				work(leaderCtx)
+			}
+		}()
+		select {
+		case <-released:
+			cancel()
+			<-workDone
+		case <-ctx.Done():
+			cancel()
+			<-workDone
+			return d.releaseOnCancel()
+		}
+	}
+}
+
+// AcquireCtx attempts to acquire the lock at `LockRetryInterval` until ctx is done, ctx then also governs the held period
+// if ctx is done before acquisition ctx.Err() is returned. once acquired, cancelling ctx destroys the session
+// returned chan receives msg once the lock is released, whatever the cause
+func (d *Dlock) AcquireCtx(ctx context.Context, value map[string]string) (<-chan bool, error) {
+	if d.permanentlyReleased() {
+		return nil, ErrPermanentlyReleased
+	}
+	acquired := make(chan bool, 1)
+	released := make(chan bool, 1)
+	if err := d.retryLockAcquire(ctx, value, acquired, released); err != nil {
+		return nil, err
+	}
+	select {
+	case <-acquired:
+	default:
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrPermanentlyReleased
+	}
+	out := make(chan bool, 1)
+	go func() {
+		select {
+		case <-released:
+		case <-ctx.Done():
+			if err := d.releaseOnCancel(); err != nil {
+				// renewal is stopped anyway, the session expires within its ttl
+				d.logger().Println("error on destroying session after context cancellation :", err)
+			}
+			<-released
+		}
+		out <- true
+	}()
+	return out, nil
+}
+
+// LockWithTimeout attempts to acquire the lock at `LockRetryInterval` for up to `timeout`
+// returns the chan which receives msg once the acquired lock is released
+// ErrAcquireTimeout is returned if the lock could not be acquired in time
+func (d *Dlock) LockWithTimeout(value map[string]string, timeout time.Duration) (<-chan bool, error) {
+	if d.permanentlyReleased() {
+		return nil, ErrPermanentlyReleased
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	acquired := make(chan bool, 1)
+	released := make(chan bool, 1)
+	if err := d.retryLockAcquire(ctx, value, acquired, released); err != nil {
+		return nil, err
+	}
+	select {
+	case <-acquired:
+		return released, nil
+	default:
+		return nil, ErrAcquireTimeout
+	}
+}
+
+// AcquireAny makes a single attempt on each of the keys in order and returns the first one acquired
+// all keys are attempted with the session of this Dlock. Lock can be released with `Release` or `DestroySession`
+// returned chan receives msg once the acquired lock is released. ErrNotAcquired is returned if every key is held by others
+func (d *Dlock) AcquireAny(keys []string, value map[string]string) (string, <-chan bool, error) {
+	if d.permanentlyReleased() {
+		return "", nil, ErrPermanentlyReleased
+	}
+	d.mu.Lock()
+	held := d.lock != nil
+	d.mu.Unlock()
+	if held {
+		return "", nil, ErrAlreadyHeld
+	}
+	released := make(chan bool, 1)
+	v := d.holderValue(value)
+	var lastErr error
+	for _, key := range keys {
+		lock, err := d.acquireKey(context.Background(), key, v, released)
+		if err != nil {
+			d.logger().Println("error on acquiring key", key, ":", err)
+			lastErr = err
+			continue
+		}
+		if lock {
+			d.logger().Printf("lock acquired on key - %s with consul session - %s", key, d.sessionID())
+			return key, released, nil
+		}
+	}
+	if lastErr != nil {
+		return "", nil, lastErr
+	}
+	return "", nil, acquireError(ReasonContended, ErrNotAcquired)
+}
+
+// AcquireWithSession makes a single attempt to lock key under a session created and renewed by the caller
+// dlock neither creates, renews nor destroys that session, and the lock is not tracked as leadership of this Dlock
+// it is released when the caller destroys the session. ErrNotAcquired is returned if key is held by someone else
+func (d *Dlock) AcquireWithSession(sessionID string, key string, value map[string]string) (<-chan bool, error) {
+	if err := d.checkParent(context.Background(), sessionID); err != nil {
+		return nil, err
+	}
+	b, err := d.Codec.Marshal(d.holderValue(value))
+	if err != nil {
+		return nil, err
+	}
+	lock, err := d.ConsulClient.LockOpts(d.lockOptions(key, b, sessionID))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := lock.Lock(nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, acquireError(ReasonContended, ErrNotAcquired)
+	}
+	d.logger().Printf("lock acquired on key - %s with caller session - %s", key, sessionID)
+	released := make(chan bool, 1)
+	go func() {
+		<-resp
+		d.logger().Printf("lock on key - %s released with caller session - %s", key, sessionID)
+		released <- true
+	}()
+	return released, nil
+}
+
+// UpdateConfig re-applies a subset of the config at runtime without dropping a held lock
+// `LockRetryInterval` applies immediately to a running retry loop, `SessionTTL` applies to sessions created afterwards
+// `SelfHandoffCooldown`, `ValueProvider`, `AcquisitionTimeFormat` and the breaker settings are updated as well
+// zero values leave the current setting untouched. The key cannot be changed
+func (d *Dlock) UpdateConfig(o *Config) error {
+	if o.ConsulKey != "" && o.ConsulKey != d.Key {
+		return fmt.Errorf("dlock: cannot change key from %q to %q", d.Key, o.ConsulKey)
+	}
+	d.mu.Lock()
+	retry, ttl := d.LockRetryInterval, d.SessionTTL
+	if o.LockRetryInterval != 0 {
+		retry = o.LockRetryInterval
+	}
+	if o.SessionTTL != 0 {
+		ttl = o.SessionTTL
+	}
+	if err := checkTiming(ttl, retry); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	d.LockRetryInterval, d.SessionTTL = retry, ttl
+	if o.SelfHandoffCooldown != 0 {
+		d.SelfHandoffCooldown = o.SelfHandoffCooldown
+	}
+	if o.ValueProvider != nil {
+		d.ValueProvider = o.ValueProvider
+	}
+	if o.AcquisitionTimeFormat != "" {
+		d.AcquisitionTimeFormat = o.AcquisitionTimeFormat
+	}
+	if o.BreakerThreshold != 0 {
+		d.breaker.threshold = o.BreakerThreshold
+	}
+	if o.BreakerCooldown != 0 {
+		d.breaker.cooldown = o.BreakerCooldown
+	}
+	d.mu.Unlock()
+	select {
+	case d.configCh <- struct{}{}:
+	default:
+	}
+	d.logger().Printf("config updated for key - %s", d.Key)
+	return nil
+}
+
+// Release voluntarily releases the held lock while keeping the Dlock usable
+// msg is sent to `released` chan as for any other release. Further `RetryLockAcquire` calls
+// wait for `SelfHandoffCooldown` before contending so that a peer can take over
+func (d *Dlock) Release() error {
+	return d.release(false)
+}
+
+// ReleaseKeepSession is `Release` which keeps the session alive and renewed
+// next acquisition reuses the session instead of creating a new one
+func (d *Dlock) ReleaseKeepSession() error {
+	return d.release(true)
+}
+
+// ReleaseWithDrain runs drain while the lock is still held, then releases the lock and destroys the consul session
+// drain is bounded by ctx, if ctx is done before drain returns the lock is released regardless
+// use it to finish in-flight work before the next leader takes over
+func (d *Dlock) ReleaseWithDrain(ctx context.Context, drain func(ctx context.Context)) error {
+	if drain != nil {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			drain(ctx)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			d.logger().Println("drain did not complete before release :", ctx.Err())
+		}
+	}
+	if err := d.Release(); err != nil {
+		return err
+	}
+	return d.DestroySession()
+}
+
+// the consul calls are made without d.mu, `releasing` keeps the release watcher from taking the release as a loss meanwhile
+func (d *Dlock) release(keepSession bool) error {
+	d.mu.Lock()
+	lock, key, sessionID := d.lock, d.heldKey, d.SessionID
+	if lock == nil || d.releasing == lock {
+		d.mu.Unlock()
+		d.logger().Printf("lock is not held. nothing to release")
+		return nil
+	}
+	d.releasing = lock
+	if keepSession {
+		d.keptLock = lock
+	}
+	d.mu.Unlock()
+	err := lock.Unlock()
+	d.mu.Lock()
+	d.releasing = nil
+	if err != nil {
+		if d.keptLock == lock {
+			d.keptLock = nil
+		}
+		d.mu.Unlock()
+		return err
+	}
+	if d.clearHeldLocked(lock) {
+		d.lastRelease = ReleaseVoluntary
+		d.handoffUntil = time.Now().Add(d.SelfHandoffCooldown)
+	}
+	d.mu.Unlock()
+	d.writeReleaseValue(key)
+	d.logger().Printf("lock voluntarily released with session - %s", sessionID)
+	return nil
+}
+
+// rotateAfter voluntarily releases the lock acquired in epoch once it has been held for max, unless it got released before
+func (d *Dlock) rotateAfter(max time.Duration, epoch int, heldCh <-chan struct{}) {
+	t := time.NewTimer(max)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-heldCh:
+		return
+	}
+	d.mu.Lock()
+	held := d.lock != nil && d.epoch == epoch
+	d.mu.Unlock()
+	if !held {
+		return
+	}
+	d.logger().Printf("lock held for max leadership duration - %s, releasing", max)
+	if err := d.Release(); err != nil {
+		d.logger().Println("error on releasing lock for rotation :", err)
 	}
 }
 
 // DestroySession invalidates the consul session and indirectly release the acquired lock if any
 // Should be called in destructor function e.g clean-up, service reload
 // this will give others a chance to acquire lock
+// Safe to call from multiple goroutines, calls after the first successful one are no-ops
 func (d *Dlock) DestroySession() error {
-	if d.SessionID == "" {
-		logger.Printf("cannot destroy empty session")
+	return d.destroySession(true)
+}
+
+// ReleaseSession destroys the consul session like `DestroySession`, releasing the lock if held,
+// but keeps the Dlock usable. Next acquisition creates a new session
+// use it for a temporary cancellation rather than a shutdown
+func (d *Dlock) ReleaseSession() error {
+	return d.destroySession(false)
+}
+
+func (d *Dlock) destroySession(permanent bool) error {
+	d.destroyMu.Lock()
+	defer d.destroyMu.Unlock()
+	if d.permanentlyReleased() {
+		return nil
+	}
+	sessionID := d.sessionID()
+	if sessionID == "" {
+		d.logger().Printf("cannot destroy empty session")
+		return nil
+	}
+	if d.OnBeforeDestroy != nil {
+		// the lock, if held, is still held here
+		d.OnBeforeDestroy(sessionID)
+	}
+	_, span := d.startSpan(context.Background(), SpanDestroySession, d.Key, sessionID)
+	d.stopRenewal(sessionID)
+	_, err := d.ConsulClient.Session().Destroy(sessionID, nil)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	d.deregisterContender()
+	d.logger().Printf("destroyed consul session - %s", sessionID)
+	d.mu.Lock()
+	if permanent {
+		d.PermanentRelease = true
+	} else if d.SessionID == sessionID {
+		d.setSessionIDLocked("")
+	}
+	if d.clearHeldLocked(d.lock) {
+		d.lastRelease = ReleaseDestroyed
+	}
+	if permanent {
+		d.setStateLocked(StatePermanentlyReleased)
+	}
+	d.mu.Unlock()
+	return nil
+}
+
+// releaseOnCancel is called once the context of `Run`, `LeadWorker` or `AcquireCtx` is done
+// it destroys the session, permanently unless `ReusableOnCancel` is set
+func (d *Dlock) releaseOnCancel() error {
+	return d.destroySession(!d.ReusableOnCancel)
+}
+
+func (d *Dlock) permanentlyReleased() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.PermanentRelease
+}
+
+// holderValue builds the value written to the lock key for an acquisition attempt
+// static value is overlaid with the output of `ValueProvider`, and `lockAcquisitionTime`, `processToken` and `holderHost` are added
+// along with `sessionTTL` and `validUntil` if `IncludeSessionExpiry` is set
+func (d *Dlock) holderValue(value map[string]string) map[string]string {
+	d.mu.Lock()
+	provider, layout, expiry, ttl := d.ValueProvider, d.AcquisitionTimeFormat, d.IncludeSessionExpiry, d.SessionTTL
+	d.mu.Unlock()
+	v := make(map[string]string, len(value)+1)
+	for k, j := range value {
+		v[k] = j
+	}
+	if provider != nil {
+		for k, j := range provider() {
+			v[k] = j
+		}
+	}
+	now := time.Now()
+	v["lockAcquisitionTime"] = formatTime(now, layout)
+	v[processTokenKey] = processToken
+	if expiry {
+		d.addExpiry(v, now, ttl, layout)
+	}
+	if hostname != "" {
+		v[holderHostKey] = hostname
+	}
+	return v
+}
+
+// formatTime formats t as per layout, which also accepts TimeFormatEpoch and TimeFormatEpochMillis
+func formatTime(t time.Time, layout string) string {
+	switch layout {
+	case TimeFormatEpoch:
+		return strconv.FormatInt(t.Unix(), 10)
+	case TimeFormatEpochMillis:
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	case "":
+		return t.Format(time.RFC3339)
+	}
+	return t.Format(layout)
+}
+
+// parseTime parses s formatted by formatTime with layout
+func parseTime(s string, layout string) (time.Time, error) {
+	switch layout {
+	case TimeFormatEpoch:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return time.Unix(n, 0), err
+	case TimeFormatEpochMillis:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return time.UnixMilli(n), err
+	case "":
+		return time.Parse(time.RFC3339, s)
+	}
+	return time.Parse(layout, s)
+}
+
+// ReadHolder returns the holder value of any lock key and its `LockIndex`, without contending for it
+// e.g. for monitoring the leaders of many keys. the value is decoded as JSON, the default `Codec`
+// nil is returned if the key does not exist, the value is returned even if the lock is currently free
+func ReadHolder(client *api.Client, key string) (map[string]string, uint64, error) {
+	pair, _, err := client.KV().Get(key, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if pair == nil {
+		return nil, 0, nil
+	}
+	var v map[string]string
+	if err := (JSONCodec{}).Unmarshal(pair.Value, &v); err != nil {
+		return nil, 0, err
+	}
+	return v, pair.LockIndex, nil
+}
+
+// CurrentHolder returns the holder value currently stored in the lock key, decoded with `Codec`
+// nil is returned if the key does not exist
+func (d *Dlock) CurrentHolder() (map[string]string, error) {
+	return d.CurrentHolderOpts(nil)
+}
+
+// CurrentHolderOpts is `CurrentHolder` with query options e.g. `RequireConsistent` before a failover decision
+// or `AllowStale` for cheap dashboard reads
+func (d *Dlock) CurrentHolderOpts(q *api.QueryOptions) (map[string]string, error) {
+	pair, _, err := d.ConsulClient.KV().Get(d.Key, q)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, nil
+	}
+	var v map[string]string
+	if err := d.Codec.Unmarshal(pair.Value, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// LockEntry returns the raw consul KV pair of the lock key, nil if the key does not exist
+// `LockIndex` of the pair counts how many times the lock has been acquired
+func (d *Dlock) LockEntry() (*api.KVPair, error) {
+	pair, _, err := d.ConsulClient.KV().Get(d.Key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// ContenderCount returns the number of contenders currently registered under `Key/contenders/`
+// Only entries still bound to a live session are counted. Requires `ContenderRegistry` on the contending instances
+func (d *Dlock) ContenderCount() (int, error) {
+	pairs, _, err := d.ConsulClient.KV().List(d.Key+contendersPrefix, nil)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, p := range pairs {
+		if p.Session != "" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// registerContender writes an entry bound to the current session under the contender prefix
+// entry is released by consul as soon as the session is invalidated
+func (d *Dlock) registerContender(value map[string]string) error {
+	sessionID := d.sessionID()
+	if sessionID == "" {
 		return nil
 	}
-	_, err := d.ConsulClient.Session().Destroy(d.SessionID, nil)
+	d.contenderMu.Lock()
+	defer d.contenderMu.Unlock()
+	key := d.Key + contendersPrefix + sessionID
+	if key == d.contenderKey {
+		return nil
+	}
+	d.deregisterContenderLocked()
+	b, err := d.Codec.Marshal(value)
 	if err != nil {
 		return err
 	}
-	logger.Printf("destroyed consul session - %s", d.SessionID)
-	d.PermanentRelease = true
+	ok, _, err := d.ConsulClient.KV().Acquire(&api.KVPair{Key: key, Value: b, Session: sessionID}, nil)
+	if err != nil {
+		return err
+	}
+	if ok {
+		d.contenderKey = key
+	}
+	d.pruneContenders()
 	return nil
 }
 
-func (d *Dlock) createSession() (string, error) {
-	return createSession(d.ConsulClient, d.Key, d.SessionTTL)
+// pruneContenders deletes the registry entries left unbound by invalidated sessions
+// consul only unbinds them, so without pruning the prefix grows with every session ever created
+// deletes are check-and-set, an entry bound again meanwhile is kept
+func (d *Dlock) pruneContenders() {
+	kv := d.ConsulClient.KV()
+	pairs, _, err := kv.List(d.Key+contendersPrefix, nil)
+	if err != nil {
+		d.logger().Println("error on listing contenders :", err)
+		return
+	}
+	for _, p := range pairs {
+		if p.Session != "" {
+			continue
+		}
+		if _, _, err := kv.DeleteCAS(p, nil); err != nil {
+			d.logger().Println("error on pruning contender :", err)
+		}
+	}
+}
+
+// deregisterContender removes the registry entry of this instance, if any
+func (d *Dlock) deregisterContender() {
+	d.contenderMu.Lock()
+	defer d.contenderMu.Unlock()
+	d.deregisterContenderLocked()
+}
+
+// deregisterContenderLocked is deregisterContender, d.contenderMu must be held
+func (d *Dlock) deregisterContenderLocked() {
+	if d.contenderKey == "" {
+		return
+	}
+	if _, err := d.ConsulClient.KV().Delete(d.contenderKey, nil); err != nil {
+		d.logger().Println("error on deregistering contender :", err)
+		return
+	}
+	d.contenderKey = ""
+}
+
+// Status returns a snapshot of the Dlock state
+func (d *Dlock) Status() Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return Status{
+		Key:                 d.Key,
+		SessionID:           d.SessionID,
+		Held:                d.lock != nil,
+		PermanentRelease:    d.PermanentRelease,
+		Breaker:             d.breaker.state(time.Now()),
+		ConsecutiveFailures: d.breaker.failures,
+		CurrentTenure:       d.currentTenureLocked(),
+		TotalLeaderTime:     d.leaderTime + d.currentTenureLocked(),
+		Epoch:               d.epoch,
+		LastRelease:         d.lastRelease,
+	}
+}
+
+// String returns a concise summary of the state, read under the lock so it is safe to log at any time
+// e.g. `dlock{key=LockKV session=abc held=true state=leader epoch=2}`
+func (d *Dlock) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return fmt.Sprintf("dlock{key=%s session=%s held=%t state=%s epoch=%d}", d.Key, d.SessionID, d.lock != nil, d.state, d.epoch)
+}
+
+// IsFirstAcquisition returns true while the lock is held for the first time by this instance
+// re-acquisitions over the lifetime of the process return false
+func (d *Dlock) IsFirstAcquisition() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lock != nil && d.epoch == 1
+}
+
+// IsLeader reports whether this instance currently holds the lock, as far as it knows
+// it is a local flag, no consul call is made
+func (d *Dlock) IsLeader() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lock != nil
+}
+
+// CurrentTenure returns the time since the lock was acquired, zero if not held
+func (d *Dlock) CurrentTenure() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.currentTenureLocked()
+}
+
+// TotalLeaderTime returns the cumulative time the lock was held by this instance, current tenure included
+func (d *Dlock) TotalLeaderTime() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.leaderTime + d.currentTenureLocked()
+}
+
+func (d *Dlock) currentTenureLocked() time.Duration {
+	if d.lock == nil {
+		return 0
+	}
+	return time.Since(d.acquiredAt)
+}
+
+// setHeldLocked records lock as held, d.mu must be held
+func (d *Dlock) setHeldLocked(lock *api.Lock) {
+	d.lock = lock
+	d.acquiredAt = time.Now()
+	d.epoch++
+	d.setStateLocked(StateLeader)
+}
+
+// clearHeldLocked records lock as no more held and accounts its tenure, d.mu must be held
+// returns false if lock was not the held one
+func (d *Dlock) clearHeldLocked(lock *api.Lock) bool {
+	if lock == nil || d.lock != lock {
+		return false
+	}
+	d.leaderTime += time.Since(d.acquiredAt)
+	d.lock = nil
+	d.setStateLocked(StateIdle)
+	return true
+}
+
+// VerifyLeadership confirms with a single consistent read that the lock key is still bound to our session
+// consul unbinds the key as soon as the session is invalidated, so this also proves the session valid
+// stricter than `IsLeader` which is local state only, use it to guard irreversible leader-only actions
+func (d *Dlock) VerifyLeadership(ctx context.Context) (bool, error) {
+	d.mu.Lock()
+	held, key, sessionID := d.lock != nil, d.heldKey, d.SessionID
+	d.mu.Unlock()
+	if !held || sessionID == "" {
+		return false, nil
+	}
+	q := (&api.QueryOptions{RequireConsistent: true}).WithContext(ctx)
+	pair, _, err := d.ConsulClient.KV().Get(key, q)
+	if err != nil {
+		return false, err
+	}
+	return pair != nil && pair.Session == sessionID, nil
+}
+
+// SessionChecks returns the ids of the checks attached to the last session created by dlock, serfHealth included
+// When the lock is released because the session got invalidated, one of these checks turned critical
+func (d *Dlock) SessionChecks() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.sessionChecks...)
+}
+
+// SessionValid reports whether the current consul session still exists
+// Can be used as a guard right before irreversible work done while holding the lock
+func (d *Dlock) SessionValid() (bool, error) {
+	sessionID := d.sessionID()
+	if sessionID == "" {
+		return false, nil
+	}
+	a, _, err := d.ConsulClient.Session().Info(sessionID, nil)
+	if err != nil {
+		return false, err
+	}
+	return a != nil, nil
+}
+
+func (d *Dlock) sessionID() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.SessionID
+}
+
+// clearSessionID forgets sessionID, found invalid, unless the session was replaced meanwhile
+func (d *Dlock) clearSessionID(sessionID string) {
+	d.mu.Lock()
+	if d.SessionID == sessionID {
+		d.setSessionIDLocked("")
+	}
+	d.mu.Unlock()
+}
+
+// setSessionIDLocked sets the session of this Dlock, d.mu must be held
+func (d *Dlock) setSessionIDLocked(sessionID string) {
+	d.SessionID = sessionID
+	d.logSession.Store(sessionID)
+}
+
+// writeReleaseValue replaces the value of key, just released voluntarily, with `OnReleaseValue` or deletes it
+// with `DeleteOnRelease`. the write is a check-and-set so a value written by the next holder is never overwritten
+func (d *Dlock) writeReleaseValue(key string) {
+	if d.OnReleaseValue == nil && !d.DeleteOnRelease {
+		return
+	}
+	kv := d.ConsulClient.KV()
+	pair, _, err := kv.Get(key, nil)
+	if err != nil || pair == nil || pair.Session != "" {
+		if err != nil {
+			d.logger().Println("error on reading released key :", err)
+		}
+		return
+	}
+	if d.DeleteOnRelease {
+		if _, _, err := kv.DeleteCAS(pair, nil); err != nil {
+			d.logger().Println("error on deleting released key :", err)
+		}
+		return
+	}
+	b, err := d.Codec.Marshal(d.OnReleaseValue)
+	if err != nil {
+		d.logger().Println("error on release value marshal", err)
+		return
+	}
+	pair.Value = b
+	if _, _, err := kv.CAS(pair, nil); err != nil {
+		d.logger().Println("error on writing release value :", err)
+	}
+}
+
+// writeStatus mirrors the holder value to `StatusKey`
+func (d *Dlock) writeStatus(b []byte) {
+	if d.StatusKey == "" {
+		return
+	}
+	if _, err := d.ConsulClient.KV().Put(&api.KVPair{Key: d.StatusKey, Value: b}, nil); err != nil {
+		d.logger().Println("error on writing status key :", err)
+	}
+}
+
+// clearStatus removes `StatusKey` unless it was overwritten meanwhile e.g. by the next holder
+func (d *Dlock) clearStatus(b []byte) {
+	if d.StatusKey == "" {
+		return
+	}
+	kv := d.ConsulClient.KV()
+	pair, _, err := kv.Get(d.StatusKey, nil)
+	if err != nil {
+		d.logger().Println("error on reading status key :", err)
+		return
+	}
+	if pair == nil || !bytes.Equal(pair.Value, b) {
+		return
+	}
+	if _, _, err := kv.DeleteCAS(pair, nil); err != nil {
+		d.logger().Println("error on clearing status key :", err)
+	}
+}
+
+func (d *Dlock) createSession(ctx context.Context) (sessionID string, err error) {
+	ctx, span := d.startSpan(ctx, SpanCreateSession, d.Key, "")
+	defer func() {
+		span.SetAttribute(AttrSessionID, sessionID)
+		endSpan(span, err)
+	}()
+	if d.SessionFactory != nil {
+		sessionID, err = d.SessionFactory()
+		if err != nil {
+			d.logger().Println("error on creating session through factory", err)
+			return "", err
+		}
+		d.mu.Lock()
+		d.sessionChecks = nil
+		d.mu.Unlock()
+		d.logger().Println("session created through factory -", sessionID)
+		return sessionID, nil
+	}
+	d.mu.Lock()
+	opts := sessionOptions{name: sessionName(d.Key, d.NodeMeta), node: d.Node, ttl: d.SessionTTL, lockDelay: d.LockDelay, checks: d.RequiredChecks}
+	if d.LockDelayFunc != nil {
+		opts.lockDelay = d.LockDelayFunc(d.lastRelease)
+	}
+	if d.FallbackChecksOnError {
+		opts.fallback = d.FallbackChecks
+	}
+	if d.TTLCheckID != "" {
+		// gated on the check instead of a ttl renewed by dlock
+		opts.ttl = 0
+		opts.checks = append(append([]string{}, d.RequiredChecks...), d.TTLCheckID)
+	}
+	opts.log = d.logger()
+	d.mu.Unlock()
+	if d.SessionCreateTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.SessionCreateTimeout)
+		defer cancel()
+	}
+	sessionID, checks, err := createSession(ctx, d.ConsulClient, opts)
+	if err != nil {
+		d.logger().Println("error on creating session", err)
+		return "", err
+	}
+	d.mu.Lock()
+	d.sessionChecks = checks
+	d.mu.Unlock()
+	d.logger().Println("created consul session -", sessionID, "with checks -", checks)
+	return sessionID, nil
 }
 
 // SetLogger sets file path for dlock logs
@@ -118,78 +1624,461 @@ func SetLogger(logpath string) {
 		log.Printf("error opening file: %v", err)
 		return
 	}
-	logger = log.New(f, "dlock:", log.Ldate|log.Ltime|log.Lshortfile)
+	SetLoggerWriter(f)
+}
+
+// SetLoggerWriter directs dlock logs to w e.g. a buffer in tests or a network sink
+func SetLoggerWriter(w io.Writer) {
+	logger = log.New(w, logger.Prefix(), logger.Flags())
 }
 
-func (d *Dlock) recreateSession() error {
-	sessionID, err := d.createSession()
+// UseLogger sets the logger of this instance, overriding the package logger
+func (d *Dlock) UseLogger(l *log.Logger) {
+	d.log.Store(l)
+}
+
+// logger returns the logger of this instance, the package logger if none is set
+// lines are converted to JSON when `LogFormat` is LogFormatJSON
+func (d *Dlock) logger() *log.Logger {
+	if d.jsonLog != nil {
+		return d.jsonLog
+	}
+	return d.baseLogger()
+}
+
+func (d *Dlock) baseLogger() *log.Logger {
+	if l, ok := d.log.Load().(*log.Logger); ok {
+		return l
+	}
+	return logger
+}
+
+// SetLoggerOptions sets prefix and flags (as in standard `log` package) of dlock logs
+// e.g. `SetLoggerOptions("[leader] ", log.LstdFlags|log.LUTC)`. Defaults are `DefaultLogPrefix` and `DefaultLogFlags`
+func SetLoggerOptions(prefix string, flags int) {
+	logger.SetPrefix(prefix)
+	logger.SetFlags(flags)
+}
+
+func (d *Dlock) recreateSession(ctx context.Context) error {
+	sessionID, err := d.createSession(ctx)
 	if err != nil {
 		return err
 	}
-	d.SessionID = sessionID
+	d.mu.Lock()
+	d.setSessionIDLocked(sessionID)
+	d.renewedAt = time.Now()
+	d.renewedTTL = d.SessionTTL
+	d.mu.Unlock()
 	return nil
 }
 
-func (d *Dlock) acquireLock(value map[string]string, released chan<- bool) (bool, error) {
-	if d.SessionID == "" {
-		err := d.recreateSession()
+// consul calls of the attempt are aborted once ctx is done
+func (d *Dlock) acquireLock(ctx context.Context, value map[string]string, released chan<- bool) (bool, error) {
+	return d.acquireKey(ctx, d.Key, value, released)
+}
+
+// acquireKey makes a single attempt to lock key with the session of this Dlock, creating the session if needed
+// on success the session is renewed until the lock is released, which is signalled on `released`
+func (d *Dlock) acquireKey(ctx context.Context, key string, value map[string]string, released chan<- bool) (bool, error) {
+	ctx, span := d.startSpan(ctx, SpanAcquireLock, key, d.sessionID())
+	ok, err := d.tryAcquireKey(ctx, key, value, released)
+	err = acquireError(ReasonUnknown, err)
+	span.SetAttribute("dlock.acquired", strconv.FormatBool(ok))
+	endSpan(span, err)
+	return ok, err
+}
+
+func (d *Dlock) tryAcquireKey(ctx context.Context, key string, value map[string]string, released chan<- bool) (bool, error) {
+	if d.sessionID() == "" {
+		err := d.recreateSession(ctx)
 		if err != nil {
-			return false, err
+			return false, acquireError(ReasonSessionCreate, err)
 		}
 	}
-	b, err := json.Marshal(value)
+	// the session may be cleared or replaced concurrently e.g. by ReleaseSession, the attempt sticks to this one
+	sessionID := d.sessionID()
+	if sessionID == "" {
+		return false, nil
+	}
+	if err := d.checkParent(ctx, sessionID); err != nil {
+		return false, err
+	}
+	proceed, err := d.inspectExisting(ctx, key, sessionID)
+	if err != nil || !proceed {
+		return false, err
+	}
+	b, err := d.Codec.Marshal(value)
 	if err != nil {
-		logger.Println("error on value marshal", err)
+		d.logger().Println("error on value marshal", err)
 	}
-	lock, err := d.ConsulClient.LockOpts(&api.LockOptions{Key: d.Key, Value: b, Session: d.SessionID, LockWaitTime: 1 * time.Second, LockTryOnce: true})
+	lock, err := d.ConsulClient.LockOpts(d.lockOptions(key, b, sessionID))
 	if err != nil {
 		return false, err
 	}
-	a, _, err := d.ConsulClient.Session().Info(d.SessionID, nil)
+	q := (&api.QueryOptions{}).WithContext(ctx)
+	a, _, err := d.ConsulClient.Session().Info(sessionID, q)
 	if err == nil && a == nil {
-		logger.Printf("consul session - %s is invalid now", d.SessionID)
-		d.SessionID = ""
+		d.logger().Printf("consul session - %s is invalid now", sessionID)
+		d.clearSessionID(sessionID)
+		d.sessionInvalidated()
 		return false, nil
 	}
 	if err != nil {
 		return false, err
 	}
 
-	resp, err := lock.Lock(nil)
+	if d.AtomicValueSet {
+		ok, err := d.acquireTxn(ctx, key, b, sessionID)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	resp, err := lock.Lock(ctx.Done())
 	if err != nil {
 		return false, err
 	}
 	if resp != nil {
-		doneCh := make(chan struct{})
-		go func() { d.ConsulClient.Session().RenewPeriodic(d.SessionTTL.String(), d.SessionID, nil, doneCh) }()
-		go func() {
-			<-resp
-			logger.Printf("lock released with session - %s", d.SessionID)
-			close(doneCh)
-			released <- true
-		}()
+		// session may have expired between the validity check above and the lock call,
+		// verify again so acquisition is never reported under an invalid session
+		a, _, err := d.ConsulClient.Session().Info(sessionID, q)
+		if err != nil || a == nil {
+			if uerr := lock.Unlock(); uerr != nil {
+				d.logger().Println("error on unlock after session verification :", uerr)
+			}
+			if err != nil {
+				return false, err
+			}
+			d.logger().Printf("consul session - %s became invalid while acquiring lock", sessionID)
+			d.clearSessionID(sessionID)
+			d.sessionInvalidated()
+			return false, nil
+		}
+		ttl := d.sessionTTL()
+		heldCh := make(chan struct{})
+		hv := &heldValue{value: value, status: b}
+		// the tenure is announced (leader state, epoch, events) only once stable, see `StabilityDelay`
+		// ended and announced are guarded by d.mu so a lock lost meanwhile is either announced and released or neither
+		// the release of a tenure never announced is signalled on inner only
+		var announced, ended bool
+		inner := make(chan bool, 1)
+		// finish runs once lock, held under sessionID, is released
+		// the session of a lock lost to a deleted key is still valid, so it is told apart from a lost session
+		finish := func(lock *api.Lock, sessionID string) {
+			d.mu.Lock()
+			ended = true
+			wasAnnounced, lost := announced, d.lock == lock && d.releasing != lock
+			d.mu.Unlock()
+			reason := ReleaseLost
+			if lost && d.keyDeleted(key) {
+				d.logger().Printf("lock key - %s deleted out of band, leadership lost", key)
+				reason = ReleaseKeyDeleted
+			}
+			d.mu.Lock()
+			// a voluntary release in progress accounts the tenure itself
+			if d.releasing != lock && d.clearHeldLocked(lock) {
+				d.lastRelease = reason
+				if reason == ReleaseLost {
+					d.churn.record(time.Now())
+				}
+			}
+			keepSession := d.keptLock == lock
+			if keepSession {
+				// only this tenure keeps its session, later ones stop the renewal as usual
+				d.keptLock = nil
+			}
+			d.mu.Unlock()
+			d.logger().Printf("lock released with session - %s", sessionID)
+			// the status may have been replaced by UpdateValueCAS
+			hv.mu.Lock()
+			status := hv.status
+			hv.mu.Unlock()
+			d.clearStatus(status)
+			if wasAnnounced {
+				d.emit(Event{Type: EventReleased, Key: key, SessionID: sessionID}, true)
+				d.notifyWebhook("released", key, sessionID, value)
+				d.audit("released", key, sessionID, value)
+			}
+			close(heldCh)
+			if !keepSession {
+				d.stopRenewal(sessionID)
+			}
+			if wasAnnounced {
+				released <- true
+			} else {
+				// only `stable` may be waiting
+				inner <- true
+			}
+		}
+		if d.SynchronousMode {
+			d.mu.Lock()
+			d.polled = &polledTenure{key: key, sessionID: sessionID, lock: lock, resp: resp, finish: finish}
+			d.mu.Unlock()
+		} else {
+			d.startRenewal(sessionID, ttl)
+			go func() {
+				lock, resp, sessionID := lock, resp, sessionID
+				for {
+					<-resp
+					h := d.takeHandover(lock)
+					if h == nil {
+						break
+					}
+					// lock moved to a new session by SetSessionTTL, keep watching that one
+					<-h.ready
+					if h.lock == nil {
+						break
+					}
+					lock, resp, sessionID = h.lock, h.resp, h.sessionID
+				}
+				finish(lock, sessionID)
+			}()
+		}
+		if d.StabilityDelay > 0 && !d.stable(ctx, lock, inner) {
+			return false, nil
+		}
+		d.mu.Lock()
+		if ended {
+			// lost right after the stability delay
+			d.mu.Unlock()
+			return false, nil
+		}
+		announced = true
+		d.setHeldLocked(lock)
+		d.heldKey = key
+		d.heldValue = hv
+		epoch := d.epoch
+		d.mu.Unlock()
+		if !d.AtomicValueSet {
+			d.writeStatus(b)
+		}
+		d.recordAcquisition(key, sessionID, q)
+		d.emit(Event{Type: EventAcquired, Key: key, SessionID: sessionID}, true)
+		d.notifyWebhook("acquired", key, sessionID, value)
+		d.audit("acquired", key, sessionID, value)
+		if !d.SynchronousMode {
+			if d.HeartbeatInterval > 0 {
+				go d.heartbeat(key, hv, heldCh)
+			}
+			if d.MaxLeadershipDuration > 0 {
+				go d.rotateAfter(d.MaxLeadershipDuration, epoch, heldCh)
+			}
+			if len(d.LeadershipSchedule) > 0 {
+				go d.releaseAtScheduleEnd(epoch, heldCh)
+			}
+		}
+		if d.OnBecomeLeader != nil {
+			d.OnBecomeLeader(epoch)
+		}
 		return true, nil
 	}
 
 	return false, nil
 }
 
-func createSession(client *api.Client, consulKey string, ttl time.Duration) (string, error) {
-	agentChecks, err := client.Agent().Checks()
+// keyDeleted returns true if key does not exist anymore
+func (d *Dlock) keyDeleted(key string) bool {
+	pair, _, err := d.ConsulClient.KV().Get(key, nil)
+	return err == nil && pair == nil
+}
+
+// stable waits for `StabilityDelay` and returns true if lock was held throughout
+// a lock lost meanwhile is never reported and a lock still held when ctx is done is unlocked
+func (d *Dlock) stable(ctx context.Context, lock *api.Lock, released <-chan bool) bool {
+	t := time.NewTimer(d.StabilityDelay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-released:
+		d.logger().Printf("lock lost within stability delay - %s, not reporting acquisition", d.StabilityDelay)
+	case <-ctx.Done():
+		if err := lock.Unlock(); err != nil {
+			d.logger().Println("error on unlock within stability delay :", err)
+		}
+		<-released
+	}
+	return false
+}
+
+// lockOptions returns the options of a single lock attempt on key under sessionID
+func (d *Dlock) lockOptions(key string, value []byte, sessionID string) *api.LockOptions {
+	opts := &api.LockOptions{
+		Key:              key,
+		Value:            value,
+		Session:          sessionID,
+		LockWaitTime:     1 * time.Second,
+		LockTryOnce:      true,
+		MonitorRetries:   d.MonitorRetries,
+		MonitorRetryTime: d.MonitorRetryTime,
+	}
+	if d.WaitForHandoff {
+		opts.LockWaitTime = d.retryInterval()
+	}
+	if d.LockOptionsFunc != nil {
+		d.LockOptionsFunc(opts)
+	}
+	return opts
+}
+
+// checkParent verifies that `ParentKey`, if set, is held by sessionID
+func (d *Dlock) checkParent(ctx context.Context, sessionID string) error {
+	if d.ParentKey == "" {
+		return nil
+	}
+	pair, _, err := d.ConsulClient.KV().Get(d.ParentKey, (&api.QueryOptions{RequireConsistent: true}).WithContext(ctx))
 	if err != nil {
-		logger.Println("error on getting checks", err)
-		return "", err
+		return err
+	}
+	if pair == nil || pair.Session == "" || pair.Session != sessionID {
+		d.logger().Printf("parent key - %s is not held by session - %s, refusing acquisition", d.ParentKey, sessionID)
+		return ErrParentNotHeld
+	}
+	return nil
+}
+
+// inspectExisting reads the value currently stored in key, if any, before a lock attempt
+// it warns when our session appears shared with another process, applies `StaleHolderPolicy` and runs `PreAcquireCheck`
+// returns false if the attempt should be skipped
+func (d *Dlock) inspectExisting(ctx context.Context, key string, sessionID string) (bool, error) {
+	pair, _, err := d.ConsulClient.KV().Get(key, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	if pair == nil {
+		return true, nil
+	}
+	if pair.Session != "" {
+		var holder map[string]string
+		if err := d.Codec.Unmarshal(pair.Value, &holder); err == nil {
+			if pair.Session == sessionID {
+				d.checkSharedSession(key, pair.Session, holder)
+			} else if staleOwnHolder(holder, d.AcquisitionTimeFormat) {
+				if proceed, err := d.handleStaleHolder(ctx, pair); err != nil || !proceed {
+					return false, err
+				}
+			}
+		}
+	}
+	if d.PreAcquireCheck == nil {
+		return true, nil
+	}
+	if err := d.PreAcquireCheck(pair.Value, pair.Flags); err != nil {
+		d.logger().Printf("existing value of key - %s refused by pre-acquire check : %v", key, err)
+		return false, fmt.Errorf("%w: %w", errPreAcquireCheck, err)
+	}
+	return true, nil
+}
+
+// sessionName returns the consul key followed by the node metadata sorted by key e.g `LockKV[dc=dc1,zone=a]`
+func sessionName(consulKey string, meta map[string]string) string {
+	if len(meta) == 0 {
+		return consulKey
+	}
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+meta[k])
+	}
+	return consulKey + "[" + strings.Join(pairs, ",") + "]"
+}
+
+// sessionOptions configure the consul session created by createSession
+type sessionOptions struct {
+	name      string
+	node      string        // empty for the agent's node
+	ttl       time.Duration // zero for a session without ttl
+	lockDelay time.Duration
+	checks    []string // attached along with serfHealth instead of every check of the node
+	fallback  []string // attached instead if the checks of the node cannot be listed, nil to fail
+	log       *log.Logger
+}
+
+// createSession returns the id of the new session and the checks attached to it
+func createSession(ctx context.Context, client *api.Client, o sessionOptions) (string, []string, error) {
+	var checks []string
+	var err error
+	if len(o.checks) > 0 {
+		checks = append([]string{"serfHealth"}, o.checks...)
+	} else if o.node == "" {
+		checks, err = agentChecks(ctx, client)
+	} else {
+		checks, err = nodeChecks(ctx, client, o.node)
+	}
+	if err != nil {
+		if o.fallback == nil {
+			return "", nil, err
+		}
+		if o.log != nil {
+			o.log.Println("error on listing checks :", err, "falling back to checks -", o.fallback)
+		}
+		checks, err = o.fallback, nil
+	}
+
+	entry := &api.SessionEntry{Name: o.name, Node: o.node, Checks: checks, LockDelay: o.lockDelay}
+	if o.ttl > 0 {
+		entry.TTL = o.ttl.String()
+	}
+	sessionID, _, err := client.Session().Create(entry, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return "", nil, err
+	}
+	return sessionID, checks, nil
+}
+
+// startSession creates a session named name with ttl and renews it in background until doneCh is closed,
+// which destroys the session. lost is called if the session is lost before that
+// it backs the types holding their keys under a single session, e.g. Manager, Semaphore and RWLock
+func startSession(client *api.Client, name string, ttl time.Duration, lost func(sessionID string, err error)) (sessionID string, doneCh chan struct{}, err error) {
+	sessionID, _, err = createSession(context.Background(), client, sessionOptions{name: name, ttl: ttl})
+	if err != nil {
+		return "", nil, err
+	}
+	doneCh = make(chan struct{})
+	go func() {
+		if err := client.Session().RenewPeriodic(ttl.String(), sessionID, nil, doneCh); err != nil {
+			lost(sessionID, err)
+		}
+	}()
+	return sessionID, doneCh, nil
+}
+
+// agentChecks returns serfHealth and all the checks configured on the local agent
+func agentChecks(ctx context.Context, client *api.Client) ([]string, error) {
+	agentChecks, err := client.Agent().ChecksWithFilterOpts("", (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
 	}
 	checks := []string{}
 	checks = append(checks, "serfHealth")
 	for _, j := range agentChecks {
 		checks = append(checks, j.CheckID)
 	}
+	return checks, nil
+}
 
-	sessionID, _, err := client.Session().Create(&api.SessionEntry{Name: consulKey, Checks: checks, LockDelay: 0 * time.Second, TTL: ttl.String()}, nil)
+// nodeChecks returns all the checks registered for node in the catalog
+// consul only accepts sessions on a registered node, so an unknown node is an error
+func nodeChecks(ctx context.Context, client *api.Client, node string) ([]string, error) {
+	q := (&api.QueryOptions{}).WithContext(ctx)
+	n, _, err := client.Catalog().Node(node, q)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	logger.Println("created consul session -", sessionID)
-	return sessionID, nil
+	if n == nil {
+		return nil, fmt.Errorf("dlock: node %q is not registered in consul catalog", node)
+	}
+	healthChecks, _, err := client.Health().Node(node, q)
+	if err != nil {
+		return nil, err
+	}
+	checks := []string{}
+	for _, j := range healthChecks {
+		checks = append(checks, j.CheckID)
+	}
+	return checks, nil
 }
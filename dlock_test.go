@@ -0,0 +1,254 @@
+package dlock
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sameervitian/dlock/internal/fakeconsul"
+)
+
+// newTestDlock returns a Dlock on key talking to srv, with o applied over short test timings
+func newTestDlock(t *testing.T, srv *fakeconsul.Server, key string, o Config) *Dlock {
+	t.Helper()
+	t.Setenv("CONSUL_HTTP_ADDR", srv.Addr())
+	o.ConsulKey = key
+	if o.LockRetryInterval == 0 {
+		o.LockRetryInterval = 50 * time.Millisecond
+	}
+	if o.SessionTTL == 0 {
+		o.SessionTTL = 10 * time.Second
+	}
+	d, err := New(&o)
+	if err != nil {
+		t.Fatalf("creating dlock: %v", err)
+	}
+	return d
+}
+
+// receive waits up to timeout for a msg on ch
+func receive(ch <-chan bool, timeout time.Duration) bool {
+	select {
+	case <-ch:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestSessionExpiredWhileAcquiring(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	// the session of the first acquisition expires right after consul granted the lock
+	var once sync.Once
+	expired := make(chan string, 1)
+	srv.AfterRequest(func(r *http.Request) {
+		if r.Method != http.MethodPut || !r.URL.Query().Has("acquire") {
+			return
+		}
+		once.Do(func() {
+			sessionID := r.URL.Query().Get("acquire")
+			srv.ExpireSession(sessionID)
+			expired <- sessionID
+		})
+	})
+
+	d := newTestDlock(t, srv, "test/expire-acquiring", Config{})
+	defer d.DestroySession()
+	acquired, released := make(chan bool, 1), make(chan bool, 1)
+	go d.RetryLockAcquire(nil, acquired, released)
+	if !receive(acquired, 5*time.Second) {
+		t.Fatal("lock not acquired")
+	}
+	sessionID := <-expired
+	if got := d.sessionID(); got == sessionID {
+		t.Fatalf("acquisition reported under expired session %s", sessionID)
+	}
+	if receive(released, 200*time.Millisecond) {
+		t.Error("release signalled for an acquisition never reported")
+	}
+}
+
+func TestAcquiredReleasedAlternate(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	d := newTestDlock(t, srv, "test/alternate", Config{})
+	defer d.DestroySession()
+	acquired, released := make(chan bool, 1), make(chan bool, 1)
+	for i := 0; i < 3; i++ {
+		go d.RetryLockAcquire(nil, acquired, released)
+		select {
+		case <-acquired:
+		case <-released:
+			t.Fatalf("tenure %d: released before acquired", i)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("tenure %d: lock not acquired", i)
+		}
+		if !srv.ExpireSession(d.Status().SessionID) {
+			t.Fatalf("tenure %d: no session to expire", i)
+		}
+		select {
+		case <-released:
+		case <-acquired:
+			t.Fatalf("tenure %d: acquired twice without release", i)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("tenure %d: release not signalled", i)
+		}
+	}
+	if got := d.Status().Epoch; got != 3 {
+		t.Errorf("epoch = %d, want 3", got)
+	}
+}
+
+func TestSessionCreateTimeout(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	// unblocks the hung create before the server waits for it on close
+	hung := make(chan struct{})
+	defer close(hung)
+	srv.BeforeRequest(func(r *http.Request) {
+		if r.URL.Path == "/v1/session/create" {
+			<-hung
+		}
+	})
+	d := newTestDlock(t, srv, "test/create-timeout", Config{SessionCreateTimeout: 200 * time.Millisecond})
+	start := time.Now()
+	_, err := d.createSession(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("create returned after %s, bounded by 200ms", elapsed)
+	}
+}
+
+func TestKeyDeletedReleasesLock(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	const key = "test/key-deleted"
+	d := newTestDlock(t, srv, key, Config{})
+	defer d.DestroySession()
+	acquired, released := make(chan bool, 1), make(chan bool, 1)
+	go d.RetryLockAcquire(nil, acquired, released)
+	if !receive(acquired, 5*time.Second) {
+		t.Fatal("lock not acquired")
+	}
+	if _, err := d.ConsulClient.KV().Delete(key, nil); err != nil {
+		t.Fatalf("deleting key: %v", err)
+	}
+	if !receive(released, 5*time.Second) {
+		t.Fatal("release not signalled after the key was deleted")
+	}
+	if got := d.Status().LastRelease; got != ReleaseKeyDeleted {
+		t.Errorf("last release = %q, want %q", got, ReleaseKeyDeleted)
+	}
+	if d.IsLeader() {
+		t.Error("still leader after the key was deleted")
+	}
+}
+
+func TestConcurrentContendersAndSessionRelease(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	const key = "test/concurrent"
+
+	holder := newTestDlock(t, srv, key, Config{ContenderRegistry: true})
+	acquired, released := make(chan bool, 1), make(chan bool, 1)
+	go holder.RetryLockAcquire(nil, acquired, released)
+	if !receive(acquired, 5*time.Second) {
+		t.Fatal("lock not acquired")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	var contenders []*Dlock
+	for i := 0; i < 3; i++ {
+		d := newTestDlock(t, srv, key, Config{ContenderRegistry: true, ReusableOnCancel: true})
+		contenders = append(contenders, d)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Run(ctx, nil)
+		}()
+	}
+	// sessions are released under the contending loops, which register and prune contenders meanwhile
+	for i := 0; i < 20; i++ {
+		for _, d := range contenders {
+			if err := d.ReleaseSession(); err != nil {
+				t.Errorf("releasing session: %v", err)
+			}
+			d.Status()
+		}
+		if _, err := holder.ContenderCount(); err != nil {
+			t.Errorf("counting contenders: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	if !holder.IsLeader() {
+		t.Error("holder lost the lock")
+	}
+	for i, d := range contenders {
+		if d.IsLeader() {
+			t.Errorf("contender %d reports being leader", i)
+		}
+	}
+	if err := holder.DestroySession(); err != nil {
+		t.Errorf("destroying session: %v", err)
+	}
+}
+
+func TestLeadWorker(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	d := newTestDlock(t, srv, "test/lead-worker", Config{})
+	starts, stops := make(chan bool, 1), make(chan bool, 1)
+	work := func(leaderCtx context.Context) {
+		starts <- true
+		<-leaderCtx.Done()
+		stops <- true
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.LeadWorker(ctx, nil, work)
+	}()
+
+	if !receive(starts, 5*time.Second) {
+		t.Fatal("work not started on acquisition")
+	}
+	srv.ExpireSession(d.sessionID())
+	if !receive(stops, 5*time.Second) {
+		t.Fatal("leaderCtx not cancelled once the lock was lost")
+	}
+	if !receive(starts, 5*time.Second) {
+		t.Fatal("work not restarted on re-acquisition")
+	}
+
+	sessionID := d.sessionID()
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("LeadWorker returned %v on cancel", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("LeadWorker did not return on cancel")
+	}
+	if !receive(stops, time.Second) {
+		t.Error("leaderCtx not cancelled with the parent ctx")
+	}
+	entry, _, err := d.ConsulClient.Session().Info(sessionID, nil)
+	if err != nil {
+		t.Fatalf("reading session: %v", err)
+	}
+	if entry != nil {
+		t.Errorf("session %s still exists after cancel", sessionID)
+	}
+}
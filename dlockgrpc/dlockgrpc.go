@@ -0,0 +1,40 @@
+// Package dlockgrpc reports dlock leadership through the standard gRPC health protocol
+// e.g. so a load balancer can route leader-only traffic to the current leader
+//
+//	srv := health.NewServer()
+//	healthpb.RegisterHealthServer(grpcServer, srv)
+//	go dlockgrpc.Watch(ctx, d, srv, "leader")
+package dlockgrpc
+
+import (
+	"context"
+
+	"github.com/sameervitian/dlock"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Watch keeps the status of service on srv in sync with the leadership of d until ctx is done
+// service is SERVING while d holds the lock and NOT_SERVING otherwise
+// a state change only triggers a fresh read of the leadership, so a change dropped on a full subscriber chan is caught up
+func Watch(ctx context.Context, d *dlock.Dlock, srv *health.Server, service string) {
+	changes, unsubscribe := d.Subscribe()
+	defer unsubscribe()
+	srv.SetServingStatus(service, status(d.IsLeader()))
+	for {
+		select {
+		case <-changes:
+			srv.SetServingStatus(service, status(d.IsLeader()))
+		case <-ctx.Done():
+			srv.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+			return
+		}
+	}
+}
+
+func status(leader bool) healthpb.HealthCheckResponse_ServingStatus {
+	if leader {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
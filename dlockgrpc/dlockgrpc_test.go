@@ -0,0 +1,61 @@
+package dlockgrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sameervitian/dlock"
+	"github.com/sameervitian/dlock/internal/fakeconsul"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// waitStatus waits up to timeout for service on srv to report want
+func waitStatus(t *testing.T, srv *health.Server, service string, want healthpb.HealthCheckResponse_ServingStatus, timeout time.Duration) {
+	t.Helper()
+	var got healthpb.HealthCheckResponse_ServingStatus
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		resp, err := srv.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err == nil {
+			got = resp.Status
+			if got == want {
+				return
+			}
+		}
+	}
+	t.Fatalf("status of %s = %s, want %s", service, got, want)
+}
+
+func TestWatchFollowsLeadership(t *testing.T) {
+	consul := fakeconsul.New()
+	defer consul.Close()
+	d, err := dlock.New(&dlock.Config{ConsulKey: "test/grpc", ConsulAddress: consul.Addr(), LockRetryInterval: 50 * time.Millisecond, SessionTTL: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("creating dlock: %v", err)
+	}
+	defer d.DestroySession()
+	srv := health.NewServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		Watch(ctx, d, srv, "leader")
+	}()
+	waitStatus(t, srv, "leader", healthpb.HealthCheckResponse_NOT_SERVING, time.Second)
+
+	acquired, released := make(chan bool, 1), make(chan bool, 1)
+	go d.RetryLockAcquire(nil, acquired, released)
+	waitStatus(t, srv, "leader", healthpb.HealthCheckResponse_SERVING, 5*time.Second)
+	<-acquired
+	consul.ExpireSession(d.Status().SessionID)
+	waitStatus(t, srv, "leader", healthpb.HealthCheckResponse_NOT_SERVING, 5*time.Second)
+	<-released
+	go d.RetryLockAcquire(nil, acquired, released)
+	waitStatus(t, srv, "leader", healthpb.HealthCheckResponse_SERVING, 5*time.Second)
+
+	cancel()
+	<-watched
+	waitStatus(t, srv, "leader", healthpb.HealthCheckResponse_NOT_SERVING, time.Second)
+}
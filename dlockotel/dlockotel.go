@@ -0,0 +1,57 @@
+// Package dlockotel adapts OpenTelemetry tracing to dlock
+//
+//	d, err := dlock.New(&dlock.Config{ConsulKey: "LockKV", Tracer: dlockotel.Global()})
+package dlockotel
+
+import (
+	"context"
+
+	"github.com/sameervitian/dlock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer obtained from the global provider
+const instrumentationName = "github.com/sameervitian/dlock"
+
+type tracer struct {
+	t trace.Tracer
+}
+
+type span struct {
+	s trace.Span
+}
+
+// New returns a dlock.Tracer creating spans with t
+func New(t trace.Tracer) dlock.Tracer {
+	return tracer{t: t}
+}
+
+// Global returns a dlock.Tracer using the global OpenTelemetry tracer provider
+func Global() dlock.Tracer {
+	return New(otel.Tracer(instrumentationName))
+}
+
+func (t tracer) Start(ctx context.Context, name string, attrs map[string]string) (context.Context, dlock.Span) {
+	kv := make([]attribute.KeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		kv = append(kv, attribute.String(k, v))
+	}
+	ctx, s := t.t.Start(ctx, name, trace.WithAttributes(kv...))
+	return ctx, span{s: s}
+}
+
+func (s span) SetAttribute(key string, value string) {
+	s.s.SetAttributes(attribute.String(key, value))
+}
+
+func (s span) RecordError(err error) {
+	s.s.RecordError(err)
+	s.s.SetStatus(codes.Error, err.Error())
+}
+
+func (s span) End() {
+	s.s.End()
+}
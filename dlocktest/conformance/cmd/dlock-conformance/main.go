@@ -0,0 +1,30 @@
+//go:build integration
+
+// dlock-conformance runs the dlock conformance scenarios against a consul agent
+//
+//	go run -tags integration ./dlocktest/conformance/cmd/dlock-conformance -addr 127.0.0.1:8500
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sameervitian/dlock/dlocktest/conformance"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8500", "address of the consul agent")
+	flag.Parse()
+	results := conformance.Run(*addr)
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Printf("FAIL %s: %v\n", r.Name, r.Err)
+			continue
+		}
+		fmt.Printf("ok   %s\n", r.Name)
+	}
+	if !conformance.Passed(results) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,215 @@
+//go:build integration
+
+// Package conformance runs the acquire/renew/release/destroy scenarios dlock is expected to pass
+// against a real consul agent, e.g. a throwaway `consul agent -dev`. It documents the expected
+// behaviour and checks that a consul setup works with dlock
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sameervitian/dlock"
+)
+
+// wait bounds how long a scenario waits for an expected signal
+const wait = 10 * time.Second
+
+// Result is the outcome of a single scenario, Err is nil if it passed
+type Result struct {
+	Name string
+	Err  error
+}
+
+type scenario struct {
+	name string
+	run  func(addr string, key string) error
+}
+
+var scenarios = []scenario{
+	{"acquire", acquire},
+	{"exclusive", exclusive},
+	{"renew", renew},
+	{"release", release},
+	{"destroy", destroy},
+}
+
+// Run runs every scenario against the consul agent at addr, each on its own key under `dlock-conformance/`
+func Run(addr string) []Result {
+	results := make([]Result, 0, len(scenarios))
+	for _, s := range scenarios {
+		key := fmt.Sprintf("dlock-conformance/%s-%d", s.name, time.Now().UnixNano())
+		results = append(results, Result{Name: s.name, Err: s.run(addr, key)})
+	}
+	return results
+}
+
+// Passed returns true if all results passed
+func Passed(results []Result) bool {
+	for _, r := range results {
+		if r.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// instance is a Dlock contending for key in background
+type instance struct {
+	d        *dlock.Dlock
+	acquired chan bool
+	released chan bool
+}
+
+func start(addr string, key string) (*instance, error) {
+	d, err := dlock.New(&dlock.Config{
+		ConsulAddress:     addr,
+		ConsulKey:         key,
+		LockRetryInterval: time.Second,
+		SessionTTL:        10 * time.Second,
+		LockDelay:         time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	i := &instance{d: d, acquired: make(chan bool, 1), released: make(chan bool, 1)}
+	go d.RetryLockAcquire(map[string]string{"scenario": key}, i.acquired, i.released)
+	return i, nil
+}
+
+func (i *instance) stop() {
+	i.d.DestroySession()
+}
+
+func expect(ch <-chan bool, what string) error {
+	select {
+	case <-ch:
+		return nil
+	case <-time.After(wait):
+		return fmt.Errorf("not %s within %s", what, wait)
+	}
+}
+
+// acquire: an uncontended lock is acquired and the holder value is readable
+func acquire(addr string, key string) error {
+	i, err := start(addr, key)
+	if err != nil {
+		return err
+	}
+	defer i.stop()
+	if err := expect(i.acquired, "acquired"); err != nil {
+		return err
+	}
+	if !i.d.IsLeader() {
+		return errors.New("acquired but not reported as leader")
+	}
+	v, err := i.d.CurrentHolder()
+	if err != nil {
+		return err
+	}
+	if v["scenario"] != key {
+		return fmt.Errorf("unexpected holder value %v", v)
+	}
+	return nil
+}
+
+// exclusive: a second contender does not acquire a held lock
+func exclusive(addr string, key string) error {
+	a, err := start(addr, key)
+	if err != nil {
+		return err
+	}
+	defer a.stop()
+	if err := expect(a.acquired, "acquired"); err != nil {
+		return err
+	}
+	b, err := start(addr, key)
+	if err != nil {
+		return err
+	}
+	defer b.stop()
+	select {
+	case <-b.acquired:
+		return errors.New("second contender acquired a held lock")
+	case <-time.After(3 * time.Second):
+	}
+	return nil
+}
+
+// renew: the lock is kept for longer than the session ttl
+func renew(addr string, key string) error {
+	i, err := start(addr, key)
+	if err != nil {
+		return err
+	}
+	defer i.stop()
+	if err := expect(i.acquired, "acquired"); err != nil {
+		return err
+	}
+	select {
+	case <-i.released:
+		return errors.New("lock lost while renewing")
+	case <-time.After(15 * time.Second):
+	}
+	ok, err := i.d.SessionValid()
+	if err != nil {
+		return err
+	}
+	if !ok || !i.d.IsLeader() {
+		return errors.New("session not valid after renewals")
+	}
+	return nil
+}
+
+// release: a voluntary release is signalled and frees the key for others
+func release(addr string, key string) error {
+	a, err := start(addr, key)
+	if err != nil {
+		return err
+	}
+	defer a.stop()
+	if err := expect(a.acquired, "acquired"); err != nil {
+		return err
+	}
+	b, err := start(addr, key)
+	if err != nil {
+		return err
+	}
+	defer b.stop()
+	if err := a.d.Release(); err != nil {
+		return err
+	}
+	if err := expect(a.released, "released"); err != nil {
+		return err
+	}
+	return expect(b.acquired, "acquired by the other contender")
+}
+
+// destroy: destroying the session releases the lock permanently and lets others take over
+func destroy(addr string, key string) error {
+	a, err := start(addr, key)
+	if err != nil {
+		return err
+	}
+	if err := expect(a.acquired, "acquired"); err != nil {
+		a.stop()
+		return err
+	}
+	b, err := start(addr, key)
+	if err != nil {
+		a.stop()
+		return err
+	}
+	defer b.stop()
+	if err := a.d.DestroySession(); err != nil {
+		return err
+	}
+	if err := expect(a.released, "released"); err != nil {
+		return err
+	}
+	if !a.d.Status().PermanentRelease {
+		return errors.New("destroyed instance not permanently released")
+	}
+	return expect(b.acquired, "acquired by the other contender")
+}
@@ -0,0 +1,115 @@
+// Package dlocktest provides helpers to check dlock invariants in tests
+// instances talk to an in-memory fake consul started by `StartCluster`, unless `ConsulAddress`
+// or the usual CONSUL_HTTP_ADDR environment points them at a consul agent e.g. a throwaway `consul agent -dev`
+package dlocktest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sameervitian/dlock"
+	"github.com/sameervitian/dlock/internal/fakeconsul"
+)
+
+// Cluster is a set of Dlock instances contending for the same key
+type Cluster struct {
+	Instances []*dlock.Dlock
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	fake   *fakeconsul.Server // nil when running against a consul agent
+}
+
+// StartCluster creates n instances from cfg and runs each of them with `Run` until `Stop`
+// a fake consul is started for them if neither `ConsulAddress` nor CONSUL_HTTP_ADDR is set
+func StartCluster(tb testing.TB, n int, cfg dlock.Config) *Cluster {
+	tb.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Cluster{cancel: cancel}
+	if cfg.ConsulAddress == "" && os.Getenv("CONSUL_HTTP_ADDR") == "" {
+		c.fake = fakeconsul.New()
+		cfg.ConsulAddress = c.fake.Addr()
+	}
+	for i := 0; i < n; i++ {
+		o := cfg
+		d, err := dlock.New(&o)
+		if err != nil {
+			c.Stop()
+			tb.Fatalf("dlocktest: creating instance %d: %v", i, err)
+		}
+		c.Instances = append(c.Instances, d)
+		value := map[string]string{"instance": fmt.Sprint(i)}
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			if err := d.Run(ctx, value); err != nil {
+				tb.Errorf("dlocktest: instance %s: %v", value["instance"], err)
+			}
+		}()
+	}
+	return c
+}
+
+// Leaders returns the indexes of the instances currently reporting `IsLeader`
+func (c *Cluster) Leaders() []int {
+	var leaders []int
+	for i, d := range c.Instances {
+		if d.IsLeader() {
+			leaders = append(leaders, i)
+		}
+	}
+	return leaders
+}
+
+// ExpireLeader destroys the session of the current leader behind its back, simulating a session loss
+// returns the index of the expired leader, -1 if there was none
+func (c *Cluster) ExpireLeader() (int, error) {
+	for i, d := range c.Instances {
+		if !d.IsLeader() {
+			continue
+		}
+		_, err := d.ConsulClient.Session().Destroy(d.Status().SessionID, nil)
+		return i, err
+	}
+	return -1, nil
+}
+
+// AssertSingleLeader samples the instances every interval for duration and fails tb
+// whenever more than one of them reports being leader at the same time
+func (c *Cluster) AssertSingleLeader(tb testing.TB, duration time.Duration, interval time.Duration) {
+	tb.Helper()
+	deadline := time.Now().Add(duration)
+	for time.Now().Before(deadline) {
+		if leaders := c.Leaders(); len(leaders) > 1 {
+			tb.Errorf("dlocktest: %d leaders at once: %v", len(leaders), leaders)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// WaitForLeader waits up to timeout for an instance to become leader and returns its index
+func (c *Cluster) WaitForLeader(tb testing.TB, timeout time.Duration) int {
+	tb.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if leaders := c.Leaders(); len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	tb.Fatalf("dlocktest: no leader within %s", timeout)
+	return -1
+}
+
+// Stop cancels every instance, which destroys their sessions, and waits for them to return
+func (c *Cluster) Stop() {
+	c.cancel()
+	c.wg.Wait()
+	if c.fake != nil {
+		c.fake.Close()
+	}
+}
@@ -0,0 +1,28 @@
+package dlocktest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sameervitian/dlock"
+)
+
+func TestExpireLeaderKeepsSingleLeader(t *testing.T) {
+	c := StartCluster(t, 3, dlock.Config{ConsulKey: "dlocktest/expire", LockRetryInterval: 100 * time.Millisecond, SessionTTL: 10 * time.Second})
+	defer c.Stop()
+
+	first := c.WaitForLeader(t, 5*time.Second)
+	sessionID := c.Instances[first].Status().SessionID
+	expired, err := c.ExpireLeader()
+	if err != nil {
+		t.Fatalf("expiring leader: %v", err)
+	}
+	if expired != first {
+		t.Fatalf("expired instance %d, leader was %d", expired, first)
+	}
+	c.AssertSingleLeader(t, 2*time.Second, 10*time.Millisecond)
+	next := c.WaitForLeader(t, 5*time.Second)
+	if got := c.Instances[next].Status().SessionID; got == sessionID {
+		t.Fatalf("instance %d still leads under expired session %s", next, sessionID)
+	}
+}
@@ -0,0 +1,101 @@
+package dlock
+
+import (
+	"sort"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// ElectionState is the full picture of the election on a lock key, see `ElectionState`
+type ElectionState struct {
+	Key        string
+	LockIndex  uint64            // number of times the lock was acquired
+	Holder     *ElectionSession  // nil while the lock is free
+	Contenders []ElectionSession // sessions registered under `Key/contenders/`, sorted by session id
+}
+
+// ElectionSession is a session taking part in an election along with the health of its checks
+type ElectionSession struct {
+	SessionID string
+	Name      string
+	Node      string
+	Value     map[string]string // holder value, or the contender registry entry of a contender
+	Checks    map[string]string // status (passing, warning, critical) of each check attached to the session
+}
+
+// ElectionState returns the holder of the lock key and the contending sessions in one call, for leadership debugging
+// contenders are listed only when the contending instances run with `ContenderRegistry`
+// the current holder is excluded from the contenders
+func (d *Dlock) ElectionState() (ElectionState, error) {
+	state := ElectionState{Key: d.Key}
+	pair, _, err := d.ConsulClient.KV().Get(d.Key, nil)
+	if err != nil {
+		return state, err
+	}
+	if pair != nil {
+		state.LockIndex = pair.LockIndex
+		if pair.Session != "" {
+			s, err := d.electionSession(pair)
+			if err != nil {
+				return state, err
+			}
+			state.Holder = s
+		}
+	}
+	pairs, _, err := d.ConsulClient.KV().List(d.Key+contendersPrefix, nil)
+	if err != nil {
+		return state, err
+	}
+	for _, p := range pairs {
+		if p.Session == "" || (state.Holder != nil && p.Session == state.Holder.SessionID) {
+			continue
+		}
+		s, err := d.electionSession(p)
+		if err != nil {
+			return state, err
+		}
+		if s != nil {
+			state.Contenders = append(state.Contenders, *s)
+		}
+	}
+	sort.Slice(state.Contenders, func(i, j int) bool {
+		return state.Contenders[i].SessionID < state.Contenders[j].SessionID
+	})
+	return state, nil
+}
+
+// electionSession describes the session holding pair, nil if the session is gone meanwhile
+func (d *Dlock) electionSession(pair *api.KVPair) (*ElectionSession, error) {
+	info, _, err := d.ConsulClient.Session().Info(pair.Session, nil)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, nil
+	}
+	s := &ElectionSession{SessionID: info.ID, Name: info.Name, Node: info.Node, Checks: map[string]string{}}
+	if err := d.Codec.Unmarshal(pair.Value, &s.Value); err != nil {
+		d.logger().Println("error on election value unmarshal :", err)
+	}
+	attached := append(append([]string{}, info.Checks...), info.NodeChecks...)
+	for _, c := range info.ServiceChecks {
+		attached = append(attached, c.ID)
+	}
+	health, _, err := d.ConsulClient.Health().Node(info.Node, nil)
+	if err != nil {
+		return nil, err
+	}
+	status := make(map[string]string, len(health))
+	for _, c := range health {
+		status[c.CheckID] = c.Status
+	}
+	for _, id := range attached {
+		if st, ok := status[id]; ok {
+			s.Checks[id] = st
+		} else {
+			// deregistered since the session was created
+			s.Checks[id] = api.HealthCritical
+		}
+	}
+	return s, nil
+}
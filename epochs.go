@@ -0,0 +1,69 @@
+package dlock
+
+import "context"
+
+// LeadershipEpoch is a single tenure of the lock yielded by `Epochs`
+type LeadershipEpoch struct {
+	Acquisition
+	// Ctx is cancelled as soon as the lock is released (session invalidation, failed check, etc.) or the ctx of `Epochs` is done
+	Ctx context.Context
+}
+
+// Epochs returns an iterator yielding a LeadershipEpoch every time the lock is acquired, e.g.
+//
+//	for epoch := range d.Epochs(ctx, value) {
+//		runWhileLeader(epoch.Ctx)
+//	}
+//
+// after the loop body returns, contention resumes once the lock is released. Breaking out of the loop or ctx
+// being done destroys the session as `Run` does on cancellation. a fatal error ends the iteration and is sent to `Errors()`
+func (d *Dlock) Epochs(ctx context.Context, value map[string]string) func(yield func(LeadershipEpoch) bool) {
+	return func(yield func(LeadershipEpoch) bool) {
+		if d.permanentlyReleased() {
+			return
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		acquired := make(chan bool, 1)
+		released := make(chan bool, 1)
+		errCh := make(chan error, 1)
+		defer func() {
+			if err := d.releaseOnCancel(); err != nil {
+				d.logger().Println("error on releasing after epochs :", err)
+			}
+		}()
+		for {
+			go func() {
+				if err := d.retryLockAcquire(ctx, value, acquired, released); err != nil {
+					errCh <- err
+				}
+			}()
+			select {
+			case <-acquired:
+			case err := <-errCh:
+				d.reportError(err)
+				return
+			case <-ctx.Done():
+				return
+			}
+			leaderCtx, leaderCancel := context.WithCancel(ctx)
+			lost := make(chan struct{})
+			go func() {
+				defer close(lost)
+				select {
+				case <-released:
+				case <-ctx.Done():
+				}
+				leaderCancel()
+			}()
+			more := yield(LeadershipEpoch{Acquisition: d.LastAcquisition(), Ctx: leaderCtx})
+			if !more {
+				return
+			}
+			<-lost
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
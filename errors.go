@@ -0,0 +1,115 @@
+package dlock
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// consul api formats non 2xx responses as `Unexpected response code: 403 (...)`
+// and the lock helpers wrap them with %v, so the code is recovered from the message as well
+var statusCodeRe = regexp.MustCompile(`Unexpected response code: (\d{3})`)
+
+// statusCode returns the HTTP status code of a consul error, 0 if it is not known
+func statusCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var se api.StatusError
+	if errors.As(err, &se) {
+		return se.Code
+	}
+	m := statusCodeRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	code, _ := strconv.Atoi(m[1])
+	return code
+}
+
+// IsFatal reports whether retrying will never fix err e.g. ACL denied (403) or bad request (400)
+// network errors, 5xx and 429 are considered retryable
+func IsFatal(err error) bool {
+	code := statusCode(err)
+	return code >= 400 && code < 500 && code != http.StatusTooManyRequests
+}
+
+// AcquireReason classifies why an acquisition failed
+type AcquireReason int
+
+const (
+	// ReasonUnknown is a failure not classified otherwise
+	ReasonUnknown AcquireReason = iota
+	// ReasonSessionCreate means the consul session could not be created
+	ReasonSessionCreate
+	// ReasonContended means the lock is held by someone else
+	ReasonContended
+	// ReasonACLDenied means the ACL token is not allowed the operation (403)
+	ReasonACLDenied
+	// ReasonUnreachable means consul could not be reached or is unavailable (network error or 5xx)
+	ReasonUnreachable
+	// ReasonRejected means a precondition refused the acquisition e.g. `PreAcquireCheck` or `ParentKey`
+	ReasonRejected
+)
+
+func (r AcquireReason) String() string {
+	switch r {
+	case ReasonUnknown:
+		return "unknown"
+	case ReasonSessionCreate:
+		return "session-create"
+	case ReasonContended:
+		return "contended"
+	case ReasonACLDenied:
+		return "acl-denied"
+	case ReasonUnreachable:
+		return "unreachable"
+	case ReasonRejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+// AcquireError is a failed acquisition with a machine readable Reason, see `errors.As`
+// the cause stays reachable through `errors.Is` / `errors.As`
+type AcquireError struct {
+	Reason AcquireReason
+	Err    error
+}
+
+func (e *AcquireError) Error() string {
+	return "dlock: acquire failed (" + e.Reason.String() + "): " + e.Err.Error()
+}
+
+func (e *AcquireError) Unwrap() error {
+	return e.Err
+}
+
+// acquireError wraps err in an AcquireError, reason is refined from err for ACL and connectivity failures
+// err already being an AcquireError is returned as is
+func acquireError(reason AcquireReason, err error) error {
+	if err == nil {
+		return nil
+	}
+	var ae *AcquireError
+	if errors.As(err, &ae) {
+		return err
+	}
+	var ne net.Error
+	code := statusCode(err)
+	switch {
+	case code == http.StatusForbidden:
+		reason = ReasonACLDenied
+	case code >= 500 || errors.As(err, &ne):
+		reason = ReasonUnreachable
+	case errors.Is(err, ErrParentNotHeld) || errors.Is(err, errPreAcquireCheck):
+		reason = ReasonRejected
+	case errors.Is(err, ErrNotAcquired):
+		reason = ReasonContended
+	}
+	return &AcquireError{Reason: reason, Err: err}
+}
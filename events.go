@@ -0,0 +1,87 @@
+package dlock
+
+import "time"
+
+// DefaultEventBuffer is the default size of the events chan buffer
+const DefaultEventBuffer = 16
+
+// EventType is the kind of a lifecycle event
+type EventType int
+
+const (
+	// EventAcquired is emitted when the lock is acquired
+	EventAcquired EventType = iota
+	// EventReleased is emitted when the held lock is released
+	EventReleased
+	// EventAttemptFailed is emitted when an acquisition attempt fails with an error
+	EventAttemptFailed
+	// EventRenewalFailed is emitted when the session could not be renewed
+	EventRenewalFailed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAcquired:
+		return "acquired"
+	case EventReleased:
+		return "released"
+	case EventAttemptFailed:
+		return "attempt-failed"
+	case EventRenewalFailed:
+		return "renewal-failed"
+	}
+	return "unknown"
+}
+
+// Event is a lifecycle event delivered on `Events()`
+type Event struct {
+	Type      EventType
+	Time      time.Time
+	Key       string
+	SessionID string
+	Err       error // set for failures
+}
+
+// DeliveryPolicy decides what happens to an event when the events chan buffer is full
+type DeliveryPolicy int
+
+const (
+	// DropNewest discards the event being emitted
+	DropNewest DeliveryPolicy = iota
+	// DropOldest discards the oldest buffered event to make room
+	DropOldest
+	// Block waits for the consumer. the renewal goroutine never blocks, it drops the event instead
+	Block
+)
+
+// Events returns the chan receiving lifecycle events
+// buffer size and behavior on a slow consumer are set through `EventBuffer` and `EventPolicy`
+func (d *Dlock) Events() <-chan Event {
+	return d.events
+}
+
+// emit delivers e as per `EventPolicy`. mayBlock is false for callers which must never stall e.g. renewal
+func (d *Dlock) emit(e Event, mayBlock bool) {
+	e.Time = time.Now()
+	switch {
+	case d.EventPolicy == Block && mayBlock:
+		d.events <- e
+	case d.EventPolicy == DropOldest:
+		for {
+			select {
+			case d.events <- e:
+				return
+			default:
+			}
+			select {
+			case <-d.events:
+			default:
+			}
+		}
+	default:
+		select {
+		case d.events <- e:
+		default:
+		}
+	}
+}
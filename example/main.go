@@ -6,8 +6,6 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"github.com/robfig/cron"
@@ -19,19 +17,19 @@ func main() {
 	var port = flag.Int64("port", 9001, "port")
 	flag.Parse()
 
-	var d *dlock.Dlock
-	var err error
+	// dlock.SetLogger("/tmp/dlock.log") // If set, dlock logs will be be directed to the file path specified, else on Stdout
+	d, err := dlock.New(&dlock.Config{ConsulKey: "LockKV", LockRetryInterval: time.Second * 10})
+	if err != nil {
+		log.Println("Error ", err)
+		return
+	}
+	// destroys the session on SIGINT/SIGTERM so the next leader takes over right away
+	done, _ := d.HandleSignals()
 
 	go func() {
 		mcron := NewMCron()
-		// dlock.SetLogger("/tmp/dlock.log") // If set, dlock logs will be be directed to the file path specified, else on Stdout
-		d, err = dlock.New(&dlock.Config{ConsulKey: "LockKV", LockRetryInterval: time.Second * 10})
-		if err != nil {
-			log.Println("Error ", err)
-			return
-		}
-		acquireCh := make(chan bool)
-		releaseCh := make(chan bool)
+		acquireCh := make(chan bool, 1)
+		releaseCh := make(chan bool, 1)
 
 		for { // loop is to re-attempt for lock acquisition when the lock was initially acquired but auto released after some time
 			log.Println("try to acquire lock")
@@ -39,12 +37,15 @@ func main() {
 			value := map[string]string{
 				"hostname": hostname,
 				// Any number of similar keys can be added
-				// key named `lockAcquisitionTime` is automatically added. This is the time at which lock is acquired. time is in RFC3339 format
+				// key named `lockAcquisitionTime` is automatically added. This is the time at which lock is acquired. time is in RFC3339 format unless `AcquisitionTimeFormat` is set
 			}
 			go d.RetryLockAcquire(value, acquireCh, releaseCh)
 			select {
 			case <-acquireCh:
 				mcron.Start() // Start the cron when lock is acquired
+			case err := <-d.Errors():
+				log.Println("stopped acquiring lock :", err) // eg. session destroyed on shutdown
+				return
 			}
 			<-releaseCh
 			mcron.Stop() // Stop the cron when lock is released
@@ -58,14 +59,8 @@ func main() {
 		errCh <- http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
 	}()
 
-	term := make(chan os.Signal)
-	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
 	select {
-	case <-term:
-		if err := d.DestroySession(); err != nil {
-			log.Println(err)
-		}
-		time.Sleep(1 * time.Second)
+	case <-done:
 		log.Println("Exiting gracefully...")
 	case err := <-errCh:
 		log.Println("Error starting web server, exiting gracefully:", err)
@@ -0,0 +1,61 @@
+package dlock
+
+import (
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+const (
+	// sessionTTLKey is the key of the holder value carrying the ttl of the holder session
+	sessionTTLKey = "sessionTTL"
+	// validUntilKey is the key of the holder value carrying the time the holder session expires unless renewed
+	validUntilKey = "validUntil"
+)
+
+// addExpiry adds `sessionTTL` and `validUntil` to the holder value v as of at
+// sessions without ttl, i.e. gated on `TTLCheckID`, carry no expiry
+func (d *Dlock) addExpiry(v map[string]string, at time.Time, ttl time.Duration, layout string) {
+	if ttl <= 0 || d.TTLCheckID != "" {
+		return
+	}
+	v[sessionTTLKey] = ttl.String()
+	v[validUntilKey] = formatTime(at.Add(ttl), layout)
+}
+
+// refreshExpiry moves `validUntil` of the held lock forward after sessionID was renewed at for ttl
+// it is a check-and-set so a concurrent write of the value is not overwritten, the next renewal catches up
+func (d *Dlock) refreshExpiry(sessionID string, at time.Time, ttl time.Duration) {
+	d.mu.Lock()
+	held, key, layout := d.lock != nil && d.SessionID == sessionID, d.heldKey, d.AcquisitionTimeFormat
+	d.mu.Unlock()
+	if !held {
+		return
+	}
+	pair, _, err := d.ConsulClient.KV().Get(key, nil)
+	if err != nil {
+		d.logger().Println("error on reading holder value for expiry :", err)
+		return
+	}
+	if pair == nil || pair.Session != sessionID {
+		return
+	}
+	var v map[string]string
+	if err := d.Codec.Unmarshal(pair.Value, &v); err != nil {
+		d.logger().Println("error on holder value unmarshal for expiry :", err)
+		return
+	}
+	d.addExpiry(v, at, ttl, layout)
+	b, err := d.Codec.Marshal(v)
+	if err != nil {
+		d.logger().Println("error on holder value marshal for expiry :", err)
+		return
+	}
+	ops := api.TxnOps{
+		&api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVCheckSession, Key: key, Session: sessionID}},
+		&api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: b, Flags: pair.Flags, Index: pair.ModifyIndex}},
+	}
+	if _, _, _, err := d.ConsulClient.Txn().Txn(ops, nil); err != nil {
+		d.logger().Println("error on refreshing holder expiry :", err)
+	}
+}
@@ -0,0 +1,34 @@
+package dlock
+
+// watchFailureSignal releases the lock and pauses contention while `FailureSignal` reports this node unhealthy
+// it returns once the chan is closed
+func (d *Dlock) watchFailureSignal(signal <-chan bool) {
+	for unhealthy := range signal {
+		d.mu.Lock()
+		changed := d.failed != unhealthy
+		d.failed = unhealthy
+		d.mu.Unlock()
+		if !changed {
+			continue
+		}
+		if !unhealthy {
+			d.logger().Println("failure signal cleared, resuming contention")
+			select {
+			case d.configCh <- struct{}{}:
+			default:
+			}
+			continue
+		}
+		d.logger().Println("failure signal received, releasing lock and pausing contention")
+		if err := d.Release(); err != nil {
+			d.logger().Println("error on releasing lock after failure signal :", err)
+		}
+	}
+}
+
+// suspended returns true while contention is paused or `FailureSignal` reports this node unhealthy
+func (d *Dlock) suspended() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused || d.failed
+}
@@ -0,0 +1,64 @@
+package dlock
+
+import (
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// heartbeat rewrites the holder value with a fresh `heartbeat` timestamp every `HeartbeatInterval`
+// until doneCh is closed, so observers can tell a live leader from one stuck while its session is renewed
+// the current session is used for every write as the lock may move to a new session meanwhile
+// the value is taken from hv on every write so an update through UpdateValueCAS is kept
+func (d *Dlock) heartbeat(key string, hv *heldValue, doneCh <-chan struct{}) {
+	ticker := time.NewTicker(d.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-doneCh:
+			return
+		}
+		d.mu.Lock()
+		provider, layout, sessionID := d.ValueProvider, d.AcquisitionTimeFormat, d.SessionID
+		expiry, renewedAt, ttl := d.IncludeSessionExpiry, d.renewedAt, d.renewedTTL
+		d.mu.Unlock()
+		// hv.mu is held until written so a concurrent UpdateValueCAS is not overwritten with the value it replaced
+		hv.mu.Lock()
+		v := make(map[string]string, len(hv.value)+1)
+		for k, j := range hv.value {
+			v[k] = j
+		}
+		if provider != nil {
+			for k, j := range provider() {
+				v[k] = j
+			}
+		}
+		v["heartbeat"] = formatTime(time.Now(), layout)
+		if expiry {
+			// the acquisition time expiry in value is outdated by now
+			d.addExpiry(v, renewedAt, ttl, layout)
+		}
+		b, err := d.Codec.Marshal(v)
+		if err != nil {
+			hv.mu.Unlock()
+			d.logger().Println("error on heartbeat value marshal", err)
+			continue
+		}
+		// a plain acquire would recreate a key deleted out of band and hide the loss from the lock monitor
+		ops := api.TxnOps{
+			&api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVCheckSession, Key: key, Session: sessionID}},
+			&api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVLock, Key: key, Value: b, Flags: api.LockFlagValue, Session: sessionID}},
+		}
+		ok, _, _, err := d.ConsulClient.Txn().Txn(ops, nil)
+		hv.mu.Unlock()
+		if err != nil {
+			d.logger().Println("error on writing heartbeat :", err)
+			continue
+		}
+		if !ok {
+			d.logger().Printf("heartbeat rejected, lock is no more held by session - %s", sessionID)
+			return
+		}
+	}
+}
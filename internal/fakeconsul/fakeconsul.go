@@ -0,0 +1,775 @@
+// Package fakeconsul is an in-memory consul HTTP API covering the session, KV, txn, agent check,
+// catalog and health endpoints dlock uses, so tests run without a consul agent
+//
+// it differs from consul where tests benefit: a session gets a lock-delay only if one is set at
+// creation (consul defaults to 15s) and the index of a single key query moves with any KV write
+package fakeconsul
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+const (
+	// Node is the only node registered in the catalog, sessions are created on it
+	Node = "fake-node"
+	// serfHealth is the node check attached to sessions by default, it is always passing
+	serfHealth = "serfHealth"
+	// ttlMultiplier is the grace consul grants a session over its ttl before invalidating it
+	ttlMultiplier = 2
+	// defaultWait bounds a blocking query without `wait`, as consul does
+	defaultWait = 5 * time.Minute
+)
+
+// Server is a fake consul agent serving the HTTP API on loopback
+type Server struct {
+	srv    *httptest.Server
+	closed chan struct{}
+
+	mu       sync.Mutex
+	index    uint64                   // last index handed out, shared by all writes
+	kvIndex  uint64                   // index of the last KV write
+	kv       map[string]*api.KVPair   // pairs are replaced, never modified in place
+	delays   map[string]time.Time     // end of the lock-delay of a key
+	sessions map[string]*session      // live sessions by id
+	checks   map[string]string        // agent checks to their status
+	changed  chan struct{}            // closed and replaced on every KV write, wakes blocking queries
+	before   func(r *http.Request)    // see BeforeRequest
+	after    func(r *http.Request)    // see AfterRequest
+	closing  map[*session]*time.Timer // ttl timers of the live sessions
+}
+
+type session struct {
+	entry    api.SessionEntry
+	ttl      time.Duration
+	deadline time.Time // session is invalidated once not renewed by then
+}
+
+// New starts a Server, `Close` stops it
+func New() *Server {
+	s := &Server{
+		closed:   make(chan struct{}),
+		index:    1,
+		kvIndex:  1,
+		kv:       map[string]*api.KVPair{},
+		delays:   map[string]time.Time{},
+		sessions: map[string]*session{},
+		checks:   map[string]string{},
+		changed:  make(chan struct{}),
+		closing:  map[*session]*time.Timer{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/kv/", s.handleKV)
+	mux.HandleFunc("/v1/txn", s.handleTxn)
+	mux.HandleFunc("/v1/session/create", s.handleSessionCreate)
+	mux.HandleFunc("/v1/session/destroy/", s.handleSessionDestroy)
+	mux.HandleFunc("/v1/session/info/", s.handleSessionInfo)
+	mux.HandleFunc("/v1/session/renew/", s.handleSessionRenew)
+	mux.HandleFunc("/v1/session/list", s.handleSessionList)
+	mux.HandleFunc("/v1/agent/checks", s.handleAgentChecks)
+	mux.HandleFunc("/v1/agent/check/update/", s.handleCheckUpdate)
+	mux.HandleFunc("/v1/catalog/node/", s.handleCatalogNode)
+	mux.HandleFunc("/v1/health/node/", s.handleHealthNode)
+	s.srv = httptest.NewServer(s.serve(mux))
+	return s
+}
+
+// Addr returns the host:port of the server, e.g. for `Config.ConsulAddress`
+func (s *Server) Addr() string {
+	return strings.TrimPrefix(s.srv.URL, "http://")
+}
+
+// Close stops the server, blocking queries in flight return right away
+func (s *Server) Close() {
+	close(s.closed)
+	s.srv.Close()
+	s.mu.Lock()
+	for _, t := range s.closing {
+		t.Stop()
+	}
+	s.mu.Unlock()
+}
+
+// BeforeRequest sets h to run before each request is handled, e.g. to delay responses
+func (s *Server) BeforeRequest(h func(r *http.Request)) {
+	s.mu.Lock()
+	s.before = h
+	s.mu.Unlock()
+}
+
+// AfterRequest sets h to run once a request is applied, before its response is sent
+// e.g. to invalidate a session right after it acquired a key
+func (s *Server) AfterRequest(h func(r *http.Request)) {
+	s.mu.Lock()
+	s.after = h
+	s.mu.Unlock()
+}
+
+// ExpireSession invalidates the session id as if its ttl or a check failed, false if it does not exist
+func (s *Server) ExpireSession(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.invalidate(id)
+}
+
+// SetCheck registers the agent check id with status or updates it
+// sessions attached to a check turning critical are invalidated, as consul does
+func (s *Server) SetCheck(id string, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setCheck(id, status)
+}
+
+// serve sets the headers every consul response carries and runs the hooks around h
+// the response is buffered so the after hook runs before the client sees it
+func (s *Server) serve(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		before := s.before
+		s.mu.Unlock()
+		if before != nil {
+			before(r)
+		}
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		rec.Header().Set("X-Consul-KnownLeader", "true")
+		rec.Header().Set("X-Consul-LastContact", "0")
+		h.ServeHTTP(rec, r)
+		s.mu.Lock()
+		after := s.after
+		s.mu.Unlock()
+		if after != nil {
+			after(r)
+		}
+		maps.Copy(w.Header(), rec.Header())
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	})
+}
+
+func (s *Server) handleKV(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		s.kvGet(w, r, key)
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		flags, _ := strconv.ParseUint(q.Get("flags"), 10, 64)
+		s.mu.Lock()
+		idx := s.index + 1
+		var ok bool
+		switch {
+		case q.Has("acquire"):
+			ok, err = s.acquire(key, q.Get("acquire"), body, flags, idx)
+		case q.Has("release"):
+			ok = s.release(key, q.Get("release"), body, flags, idx)
+		case q.Has("cas"):
+			cas, _ := strconv.ParseUint(q.Get("cas"), 10, 64)
+			ok = s.cas(key, cas, body, flags, idx)
+		default:
+			s.set(key, body, flags, idx)
+			ok = true
+		}
+		if ok {
+			s.commit(idx, true)
+		}
+		s.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, ok)
+	case http.MethodDelete:
+		s.mu.Lock()
+		idx := s.index + 1
+		ok := true
+		switch {
+		case q.Has("recurse"):
+			s.deleteTree(key)
+		case q.Has("cas"):
+			cas, _ := strconv.ParseUint(q.Get("cas"), 10, 64)
+			ok = s.deleteCAS(key, cas)
+		default:
+			delete(s.kv, key)
+		}
+		if ok {
+			s.commit(idx, true)
+		}
+		s.mu.Unlock()
+		writeJSON(w, ok)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// kvGet serves a key or with `recurse` a prefix, blocking while `index` is given and current
+func (s *Server) kvGet(w http.ResponseWriter, r *http.Request, key string) {
+	q := r.URL.Query()
+	if q.Has("keys") {
+		http.Error(w, "fakeconsul: keys queries are not supported", http.StatusBadRequest)
+		return
+	}
+	recurse := q.Has("recurse")
+	index, _ := strconv.ParseUint(q.Get("index"), 10, 64)
+	wait := defaultWait
+	if v := q.Get("wait"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			wait = d
+		}
+	}
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	for {
+		s.mu.Lock()
+		pairs, current := s.read(key, recurse)
+		changed := s.changed
+		var b []byte
+		if len(pairs) > 0 {
+			b, _ = json.Marshal(pairs)
+		}
+		s.mu.Unlock()
+		if index == 0 || current > index {
+			s.writeRead(w, current, b)
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			s.writeRead(w, current, b)
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func (s *Server) writeRead(w http.ResponseWriter, index uint64, b []byte) {
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	if b == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write(b)
+}
+
+// read returns the pair of key, or the pairs under it if recurse, along with the index of the result
+func (s *Server) read(key string, recurse bool) ([]*api.KVPair, uint64) {
+	if !recurse {
+		if p := s.kv[key]; p != nil {
+			return []*api.KVPair{p}, p.ModifyIndex
+		}
+		return nil, s.kvIndex
+	}
+	var pairs []*api.KVPair
+	for k, p := range s.kv {
+		if strings.HasPrefix(k, key) {
+			pairs = append(pairs, p)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs, s.kvIndex
+}
+
+// commit makes idx the current index, waking blocking queries if kv changed. s.mu must be held
+func (s *Server) commit(idx uint64, kv bool) {
+	s.index = idx
+	if !kv {
+		return
+	}
+	s.kvIndex = idx
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// set writes key keeping its lock, as a plain PUT does
+func (s *Server) set(key string, value []byte, flags uint64, idx uint64) *api.KVPair {
+	p := &api.KVPair{Key: key, Value: value, Flags: flags, CreateIndex: idx, ModifyIndex: idx}
+	if old := s.kv[key]; old != nil {
+		p.CreateIndex, p.LockIndex, p.Session = old.CreateIndex, old.LockIndex, old.Session
+	}
+	s.kv[key] = p
+	return p
+}
+
+// acquire locks key for sessionID. false if another session holds it or it is in its lock-delay
+func (s *Server) acquire(key string, sessionID string, value []byte, flags uint64, idx uint64) (bool, error) {
+	if s.sessions[sessionID] == nil {
+		return false, fmt.Errorf("invalid session %q", sessionID)
+	}
+	if until, ok := s.delays[key]; ok && time.Now().Before(until) {
+		return false, nil
+	}
+	old := s.kv[key]
+	if old != nil && old.Session != "" && old.Session != sessionID {
+		return false, nil
+	}
+	p := &api.KVPair{Key: key, Value: value, Flags: flags, Session: sessionID, CreateIndex: idx, ModifyIndex: idx, LockIndex: 1}
+	if old != nil {
+		p.CreateIndex, p.LockIndex = old.CreateIndex, old.LockIndex
+		if old.Session != sessionID {
+			p.LockIndex++
+		}
+	}
+	s.kv[key] = p
+	return true, nil
+}
+
+// release unlocks key held by sessionID, the value is replaced as consul does
+func (s *Server) release(key string, sessionID string, value []byte, flags uint64, idx uint64) bool {
+	old := s.kv[key]
+	if old == nil || old.Session != sessionID {
+		return false
+	}
+	p := *old
+	p.Value, p.Flags, p.Session, p.ModifyIndex = value, flags, "", idx
+	s.kv[key] = &p
+	return true
+}
+
+// cas writes key if its modify index is cas, zero meaning the key must not exist
+func (s *Server) cas(key string, cas uint64, value []byte, flags uint64, idx uint64) bool {
+	old := s.kv[key]
+	if (cas == 0 && old != nil) || (cas != 0 && (old == nil || old.ModifyIndex != cas)) {
+		return false
+	}
+	s.set(key, value, flags, idx)
+	return true
+}
+
+func (s *Server) deleteCAS(key string, cas uint64) bool {
+	old := s.kv[key]
+	if old != nil && old.ModifyIndex != cas {
+		return false
+	}
+	delete(s.kv, key)
+	return true
+}
+
+func (s *Server) deleteTree(prefix string) {
+	for k := range s.kv {
+		if strings.HasPrefix(k, prefix) {
+			delete(s.kv, k)
+		}
+	}
+}
+
+// handleTxn applies the KV operations of a transaction atomically
+// a failing operation rolls back the others and is reported with a 409 as consul does
+func (s *Server) handleTxn(w http.ResponseWriter, r *http.Request) {
+	var ops api.TxnOps
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, op := range ops {
+		if op.KV == nil {
+			http.Error(w, "fakeconsul: only KV operations are supported in a txn", http.StatusBadRequest)
+			return
+		}
+	}
+	s.mu.Lock()
+	kv, delays := maps.Clone(s.kv), maps.Clone(s.delays)
+	idx := s.index + 1
+	var resp api.TxnResponse
+	written := false
+	for i, op := range ops {
+		p, write, err := s.kvOp(op.KV, idx)
+		if err != nil {
+			resp.Errors = append(resp.Errors, &api.TxnError{OpIndex: i, What: err.Error()})
+			continue
+		}
+		written = written || write
+		for _, p := range p {
+			resp.Results = append(resp.Results, &api.TxnResult{KV: p})
+		}
+	}
+	if len(resp.Errors) > 0 {
+		s.kv, s.delays = kv, delays
+		s.mu.Unlock()
+		resp.Results = nil
+		w.Header().Set("X-Consul-Index", "0")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if written {
+		s.commit(idx, true)
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index, 10))
+	s.mu.Unlock()
+	writeJSON(w, resp)
+}
+
+// kvOp applies a single KV operation of a txn at idx, returns the pairs to report and whether it wrote
+func (s *Server) kvOp(op *api.KVTxnOp, idx uint64) ([]*api.KVPair, bool, error) {
+	old := s.kv[op.Key]
+	switch op.Verb {
+	case api.KVSet:
+		return []*api.KVPair{noValue(s.set(op.Key, op.Value, op.Flags, idx))}, true, nil
+	case api.KVCAS:
+		if !s.cas(op.Key, op.Index, op.Value, op.Flags, idx) {
+			return nil, false, fmt.Errorf("failed to set key %q, index is stale", op.Key)
+		}
+		return []*api.KVPair{noValue(s.kv[op.Key])}, true, nil
+	case api.KVLock:
+		ok, err := s.acquire(op.Key, op.Session, op.Value, op.Flags, idx)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			return nil, false, fmt.Errorf("failed to lock key %q, lock is already held", op.Key)
+		}
+		return []*api.KVPair{noValue(s.kv[op.Key])}, true, nil
+	case api.KVUnlock:
+		if !s.release(op.Key, op.Session, op.Value, op.Flags, idx) {
+			return nil, false, fmt.Errorf("failed to unlock key %q, lock isn't held, or is held by another session", op.Key)
+		}
+		return []*api.KVPair{noValue(s.kv[op.Key])}, true, nil
+	case api.KVGet:
+		if old == nil {
+			return nil, false, fmt.Errorf("key %q doesn't exist", op.Key)
+		}
+		return []*api.KVPair{copyPair(old)}, false, nil
+	case api.KVGetOrEmpty:
+		if old == nil {
+			return nil, false, nil
+		}
+		return []*api.KVPair{copyPair(old)}, false, nil
+	case api.KVGetTree:
+		pairs, _ := s.read(op.Key, true)
+		for i, p := range pairs {
+			pairs[i] = copyPair(p)
+		}
+		return pairs, false, nil
+	case api.KVCheckIndex:
+		if old == nil || old.ModifyIndex != op.Index {
+			return nil, false, fmt.Errorf("failed index check for key %q", op.Key)
+		}
+		return []*api.KVPair{noValue(old)}, false, nil
+	case api.KVCheckSession:
+		if old == nil || old.Session != op.Session {
+			return nil, false, fmt.Errorf("failed session check for key %q", op.Key)
+		}
+		return []*api.KVPair{noValue(old)}, false, nil
+	case api.KVCheckNotExists:
+		if old != nil {
+			return nil, false, fmt.Errorf("key %q exists", op.Key)
+		}
+		return nil, false, nil
+	case api.KVDelete:
+		delete(s.kv, op.Key)
+		return nil, true, nil
+	case api.KVDeleteCAS:
+		if !s.deleteCAS(op.Key, op.Index) {
+			return nil, false, fmt.Errorf("failed to delete key %q, index is stale", op.Key)
+		}
+		return nil, true, nil
+	case api.KVDeleteTree:
+		s.deleteTree(op.Key)
+		return nil, true, nil
+	}
+	return nil, false, fmt.Errorf("unknown KV verb %q", op.Verb)
+}
+
+// sessionRequest is the body of a session create, LockDelay is sent as a duration string
+type sessionRequest struct {
+	Name          string
+	Node          string
+	LockDelay     string
+	Checks        []string
+	NodeChecks    *[]string
+	ServiceChecks []api.ServiceCheck
+	Behavior      string
+	TTL           string
+}
+
+func (s *Server) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
+	var req sessionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Node == "" {
+		req.Node = Node
+	}
+	if req.Node != Node {
+		http.Error(w, fmt.Sprintf("Missing node registration for %q", req.Node), http.StatusInternalServerError)
+		return
+	}
+	if req.Behavior == "" {
+		req.Behavior = api.SessionBehaviorRelease
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl < 10*time.Second || ttl > 24*time.Hour {
+			http.Error(w, fmt.Sprintf("Invalid Session TTL '%s', must be between [10s=24h0m0s]", req.TTL), http.StatusBadRequest)
+			return
+		}
+	}
+	var lockDelay time.Duration
+	if req.LockDelay != "" {
+		var err error
+		if lockDelay, err = time.ParseDuration(req.LockDelay); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	checks := req.Checks
+	if req.NodeChecks != nil {
+		checks = append(checks, *req.NodeChecks...)
+	} else if len(checks) == 0 {
+		checks = []string{serfHealth}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range checks {
+		status, ok := s.checkStatus(c)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Missing check '%s' registration", c), http.StatusInternalServerError)
+			return
+		}
+		if status == api.HealthCritical {
+			http.Error(w, fmt.Sprintf("Check '%s' is in critical state", c), http.StatusInternalServerError)
+			return
+		}
+	}
+	idx := s.index + 1
+	sess := &session{ttl: ttl, entry: api.SessionEntry{
+		CreateIndex:   idx,
+		ID:            newID(),
+		Name:          req.Name,
+		Node:          req.Node,
+		LockDelay:     lockDelay,
+		Behavior:      req.Behavior,
+		TTL:           req.TTL,
+		Checks:        checks,
+		NodeChecks:    checks,
+		ServiceChecks: req.ServiceChecks,
+	}}
+	s.sessions[sess.entry.ID] = sess
+	s.commit(idx, false)
+	if ttl > 0 {
+		sess.deadline = time.Now().Add(ttl * ttlMultiplier)
+		s.closing[sess] = time.AfterFunc(ttl*ttlMultiplier, func() { s.expire(sess) })
+	}
+	writeJSON(w, map[string]string{"ID": sess.entry.ID})
+}
+
+// expire invalidates sess once its deadline passed without renewal
+func (s *Server) expire(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions[sess.entry.ID] != sess {
+		return
+	}
+	if left := time.Until(sess.deadline); left > 0 {
+		s.closing[sess].Reset(left)
+		return
+	}
+	s.invalidate(sess.entry.ID)
+}
+
+// invalidate removes session id, releasing or deleting the keys it holds per its behavior
+// keys are kept from being acquired for the lock-delay of the session. s.mu must be held
+func (s *Server) invalidate(id string) bool {
+	sess := s.sessions[id]
+	if sess == nil {
+		return false
+	}
+	delete(s.sessions, id)
+	if t := s.closing[sess]; t != nil {
+		t.Stop()
+		delete(s.closing, sess)
+	}
+	idx := s.index + 1
+	kv := false
+	for k, p := range s.kv {
+		if p.Session != id {
+			continue
+		}
+		kv = true
+		if sess.entry.Behavior == api.SessionBehaviorDelete {
+			delete(s.kv, k)
+		} else {
+			np := *p
+			np.Session, np.ModifyIndex = "", idx
+			s.kv[k] = &np
+		}
+		if sess.entry.LockDelay > 0 {
+			s.delays[k] = time.Now().Add(sess.entry.LockDelay)
+		}
+	}
+	s.commit(idx, kv)
+	return true
+}
+
+func (s *Server) handleSessionDestroy(w http.ResponseWriter, r *http.Request) {
+	s.ExpireSession(strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/"))
+	writeJSON(w, true)
+}
+
+func (s *Server) handleSessionInfo(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/session/info/")
+	s.mu.Lock()
+	entries := []api.SessionEntry{}
+	if sess := s.sessions[id]; sess != nil {
+		entries = append(entries, sess.entry)
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index, 10))
+	s.mu.Unlock()
+	writeJSON(w, entries)
+}
+
+func (s *Server) handleSessionList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	entries := []api.SessionEntry{}
+	for _, sess := range s.sessions {
+		entries = append(entries, sess.entry)
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index, 10))
+	s.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CreateIndex < entries[j].CreateIndex })
+	writeJSON(w, entries)
+}
+
+func (s *Server) handleSessionRenew(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")
+	s.mu.Lock()
+	sess := s.sessions[id]
+	if sess == nil {
+		s.mu.Unlock()
+		http.Error(w, fmt.Sprintf("Session id '%s' not found", id), http.StatusNotFound)
+		return
+	}
+	if sess.ttl > 0 {
+		sess.deadline = time.Now().Add(sess.ttl * ttlMultiplier)
+	}
+	entry := sess.entry
+	s.mu.Unlock()
+	writeJSON(w, []api.SessionEntry{entry})
+}
+
+// checkStatus returns the status of the check id, false if it is not registered. s.mu must be held
+func (s *Server) checkStatus(id string) (string, bool) {
+	if id == serfHealth {
+		return api.HealthPassing, true
+	}
+	status, ok := s.checks[id]
+	return status, ok
+}
+
+// setCheck sets the status of the agent check id, invalidating its sessions if critical. s.mu must be held
+func (s *Server) setCheck(id string, status string) {
+	s.checks[id] = status
+	if status != api.HealthCritical {
+		return
+	}
+	for sid, sess := range s.sessions {
+		for _, c := range sess.entry.NodeChecks {
+			if c == id {
+				s.invalidate(sid)
+				break
+			}
+		}
+	}
+}
+
+func (s *Server) handleAgentChecks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	checks := make(map[string]*api.AgentCheck, len(s.checks))
+	for id, status := range s.checks {
+		checks[id] = &api.AgentCheck{Node: Node, CheckID: id, Name: id, Status: status}
+	}
+	s.mu.Unlock()
+	writeJSON(w, checks)
+}
+
+func (s *Server) handleCheckUpdate(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/agent/check/update/")
+	var update struct{ Status string }
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.checks[id]; !ok {
+		http.Error(w, fmt.Sprintf("Unknown check ID %q", id), http.StatusNotFound)
+		return
+	}
+	s.setCheck(id, update.Status)
+}
+
+func (s *Server) handleCatalogNode(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimPrefix(r.URL.Path, "/v1/catalog/node/") != Node {
+		writeJSON(w, nil)
+		return
+	}
+	writeJSON(w, api.CatalogNode{Node: &api.Node{Node: Node, Address: "127.0.0.1"}})
+}
+
+func (s *Server) handleHealthNode(w http.ResponseWriter, r *http.Request) {
+	checks := []*api.HealthCheck{}
+	if strings.TrimPrefix(r.URL.Path, "/v1/health/node/") == Node {
+		checks = append(checks, &api.HealthCheck{Node: Node, CheckID: serfHealth, Name: "Serf Health Status", Status: api.HealthPassing})
+		s.mu.Lock()
+		for id, status := range s.checks {
+			checks = append(checks, &api.HealthCheck{Node: Node, CheckID: id, Name: id, Status: status})
+		}
+		s.mu.Unlock()
+	}
+	writeJSON(w, checks)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(b.Bytes())
+}
+
+func copyPair(p *api.KVPair) *api.KVPair {
+	c := *p
+	return &c
+}
+
+// noValue returns a copy of p without its value, as consul reports written pairs in a txn
+func noValue(p *api.KVPair) *api.KVPair {
+	c := *p
+	c.Value = nil
+	return &c
+}
+
+// newID returns a random UUID, the format of consul session ids
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
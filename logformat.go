@@ -0,0 +1,56 @@
+package dlock
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LogFormatText logs plain text lines through log.Logger, the default
+	LogFormatText = "text"
+	// LogFormatJSON logs one JSON object per line with `ts`, `level`, `msg`, `key` and `session_id`
+	LogFormatJSON = "json"
+)
+
+// jsonWriter turns each line written by a log.Logger into a JSON object on the writer of the logger of d
+// key and session are read per line so one writer serves the Dlock across sessions
+type jsonWriter struct {
+	mu sync.Mutex // serializes writes to the underlying writer
+	d  *Dlock
+}
+
+type jsonLine struct {
+	Ts        string `json:"ts"`
+	Level     string `json:"level"`
+	Msg       string `json:"msg"`
+	Key       string `json:"key,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+func (j *jsonWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level := "info"
+	if strings.HasPrefix(msg, "error") {
+		level = "error"
+	}
+	sessionID, _ := j.d.logSession.Load().(string)
+	b, err := json.Marshal(jsonLine{Ts: time.Now().UTC().Format(time.RFC3339Nano), Level: level, Msg: msg, Key: j.d.Key, SessionID: sessionID})
+	if err != nil {
+		return 0, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.d.baseLogger().Writer().Write(append(b, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// newJSONLogger returns the logger writing lines as JSON with the key and session of this Dlock
+// created once per Dlock, lines go to the writer of its current logger
+func (d *Dlock) newJSONLogger() *log.Logger {
+	return log.New(&jsonWriter{d: d}, "", 0)
+}
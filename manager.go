@@ -0,0 +1,140 @@
+package dlock
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// ManagerConfig is used to configure a Manager
+type ManagerConfig struct {
+	Prefix     string        // KV prefix of the locks, each lock is `Prefix/suffix`
+	SessionTTL time.Duration // ttl of the session shared by all locks. defaults to DefautSessionTTL
+	Logger     *log.Logger   // logger of this manager. defaults to the package logger set through SetLogger/SetLoggerWriter
+}
+
+// Manager acquires and releases any number of locks under a common KV prefix, e.g. to own partitions of work
+// all locks share one renewed session, if the session is invalidated every lock is lost at once
+type Manager struct {
+	ConsulClient *api.Client
+	Prefix       string
+	SessionTTL   time.Duration
+	SessionID    string
+	Logger       *log.Logger
+
+	mu     sync.Mutex
+	held   map[string]bool
+	doneCh chan struct{} // stops the session renewal
+}
+
+// NewManager returns a new Manager for locks under prefix
+func NewManager(client *api.Client, o *ManagerConfig) (*Manager, error) {
+	m := &Manager{ConsulClient: client, Prefix: o.Prefix, SessionTTL: DefautSessionTTL, Logger: o.Logger, held: map[string]bool{}}
+	if o.SessionTTL != 0 {
+		m.SessionTTL = o.SessionTTL
+	}
+	return m, nil
+}
+
+// Acquire makes a single attempt to lock `Prefix/suffix`, returns true if it is held after the call
+func (m *Manager) Acquire(suffix string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.held[suffix] {
+		return true, nil
+	}
+	if err := m.ensureSession(); err != nil {
+		return false, err
+	}
+	b, err := json.Marshal(map[string]string{"lockAcquisitionTime": time.Now().Format(time.RFC3339)})
+	if err != nil {
+		return false, err
+	}
+	ok, _, err := m.ConsulClient.KV().Acquire(&api.KVPair{Key: m.key(suffix), Value: b, Session: m.SessionID, Flags: api.LockFlagValue}, nil)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		m.held[suffix] = true
+	}
+	return ok, nil
+}
+
+// Release unlocks `Prefix/suffix` if held, the shared session stays alive for the other locks
+func (m *Manager) Release(suffix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.held[suffix] {
+		return nil
+	}
+	if _, _, err := m.ConsulClient.KV().Release(&api.KVPair{Key: m.key(suffix), Session: m.SessionID, Flags: api.LockFlagValue}, nil); err != nil {
+		return err
+	}
+	delete(m.held, suffix)
+	return nil
+}
+
+// Held returns the sorted suffixes of the locks currently held
+func (m *Manager) Held() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	held := make([]string, 0, len(m.held))
+	for suffix := range m.held {
+		held = append(held, suffix)
+	}
+	sort.Strings(held)
+	return held
+}
+
+// Close destroys the shared session which releases all held locks
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.SessionID == "" {
+		return nil
+	}
+	close(m.doneCh) // renewal destroys the session
+	m.SessionID = ""
+	m.held = map[string]bool{}
+	return nil
+}
+
+// logger returns the logger of this Manager, the package logger unless `Logger` is set
+func (m *Manager) logger() *log.Logger {
+	if m.Logger != nil {
+		return m.Logger
+	}
+	return logger
+}
+
+func (m *Manager) ensureSession() error {
+	if m.SessionID != "" {
+		return nil
+	}
+	sessionID, doneCh, err := startSession(m.ConsulClient, m.Prefix, m.SessionTTL, m.sessionLost)
+	if err != nil {
+		return err
+	}
+	m.SessionID, m.doneCh = sessionID, doneCh
+	return nil
+}
+
+// sessionLost drops all locks once sessionID is lost
+func (m *Manager) sessionLost(sessionID string, err error) {
+	m.logger().Printf("manager session - %s lost : %s", sessionID, err)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.SessionID != sessionID {
+		return
+	}
+	m.SessionID = ""
+	m.held = map[string]bool{}
+}
+
+func (m *Manager) key(suffix string) string {
+	return m.Prefix + "/" + suffix
+}
@@ -0,0 +1,269 @@
+package dlock
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// minorityTimeoutFactor is how many retry intervals a minority of datacenters is held at most by default
+const minorityTimeoutFactor = 2
+
+// MultiDCConfig is used to configure a MultiDCLock
+type MultiDCConfig struct {
+	Config          Config            // config of the lock in every datacenter, Datacenter and Node are set per datacenter
+	Datacenters     []string          // datacenters to contend in
+	Nodes           map[string]string // node the session is bound to per datacenter. required for every datacenter but the local one as sessions need a node of their datacenter
+	Quorum          int               // datacenters in which the lock must be held. defaults to a majority
+	MinorityTimeout time.Duration     // locks held in fewer than Quorum datacenters are released after this plus up to as much jitter, so contenders splitting the datacenters do not deadlock. defaults to 2 lock retry intervals
+}
+
+// MultiDCLock is leadership over a quorum of datacenters, guarding against a regional split-brain
+// it contends for the same key in every datacenter and reports acquisition only while the lock is held in a quorum
+// of them. once the quorum is lost the lock is released everywhere and contention starts over
+type MultiDCLock struct {
+	Quorum          int
+	MinorityTimeout time.Duration
+	Logger          *log.Logger // logger of the quorum changes, `Config.Logger`
+
+	locks   map[string]*Dlock
+	mu      sync.Mutex
+	held    map[string]bool
+	leader  bool
+	signals []bool        // quorum gains (true) and losses (false) not yet delivered
+	wake    chan struct{} // signals were queued
+}
+
+// NewMultiDCLock returns a MultiDCLock with a Dlock per datacenter
+func NewMultiDCLock(o *MultiDCConfig) (*MultiDCLock, error) {
+	if len(o.Datacenters) == 0 {
+		return nil, fmt.Errorf("dlock: no datacenters configured")
+	}
+	m := &MultiDCLock{Quorum: len(o.Datacenters)/2 + 1, Logger: o.Config.Logger, locks: map[string]*Dlock{}, held: map[string]bool{}, wake: make(chan struct{}, 1)}
+	if o.Quorum != 0 {
+		m.Quorum = o.Quorum
+	}
+	m.MinorityTimeout = o.MinorityTimeout
+	if m.MinorityTimeout <= 0 {
+		retry := o.Config.LockRetryInterval
+		if retry == 0 {
+			retry = DefaultLockRetryInterval
+		}
+		m.MinorityTimeout = minorityTimeoutFactor * retry
+	}
+	if m.Quorum < 1 || m.Quorum > len(o.Datacenters) {
+		return nil, fmt.Errorf("dlock: quorum %d out of range for %d datacenters", m.Quorum, len(o.Datacenters))
+	}
+	for _, dc := range o.Datacenters {
+		c := o.Config
+		c.Datacenter = dc
+		if node, ok := o.Nodes[dc]; ok {
+			c.Node = node
+		}
+		d, err := New(&c)
+		if err != nil {
+			return nil, fmt.Errorf("dlock: datacenter %s: %w", dc, err)
+		}
+		m.locks[dc] = d
+	}
+	return m, nil
+}
+
+// logger returns the logger of this MultiDCLock, the package logger unless `Logger` is set
+func (m *MultiDCLock) logger() *log.Logger {
+	if m.Logger != nil {
+		return m.Logger
+	}
+	return logger
+}
+
+// Run contends in every datacenter until ctx is done, then destroys all sessions
+// msg is sent to `acquired` once the lock is held in a quorum of datacenters and to `released` once the quorum is lost
+// signals are sent in order from a goroutine of their own, a slow receiver does not hold up the datacenters
+// locks held in a minority of datacenters for `MinorityTimeout` are released and contended for again after a random pause
+// returns the first fatal error (see `IsFatal`) of any datacenter, contention stops everywhere in that case
+func (m *MultiDCLock) Run(ctx context.Context, value map[string]string, acquired chan<- bool, released chan<- bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		m.deliver(ctx, acquired, released)
+	}()
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(m.locks))
+	for dc, d := range m.locks {
+		wg.Add(1)
+		go func(dc string, d *Dlock) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				rel, err := d.AcquireCtx(ctx, value)
+				if err != nil {
+					if IsFatal(err) {
+						errCh <- fmt.Errorf("dlock: datacenter %s: %w", dc, err)
+						cancel()
+					}
+					return
+				}
+				m.update(dc, true)
+				yielded := m.hold(dc, d, rel)
+				m.update(dc, false)
+				if yielded && !sleepCtx(ctx, jitter(m.MinorityTimeout)) {
+					return
+				}
+			}
+		}(dc, d)
+	}
+	wg.Wait()
+	cancel()
+	<-delivered
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// update records whether the lock is held in dc and queues the signal of a gained or lost quorum
+func (m *MultiDCLock) update(dc string, held bool) {
+	m.mu.Lock()
+	if held {
+		m.held[dc] = true
+	} else {
+		delete(m.held, dc)
+	}
+	switch {
+	case !m.leader && len(m.held) >= m.Quorum:
+		m.leader = true
+		m.logger().Printf("lock held in quorum of datacenters - %v", m.heldLocked())
+		m.queueLocked(true)
+		m.mu.Unlock()
+		return
+	case m.leader && len(m.held) < m.Quorum:
+		m.leader = false
+		var rest []*Dlock
+		for dc := range m.held {
+			rest = append(rest, m.locks[dc])
+		}
+		m.logger().Printf("lock quorum of datacenters lost, releasing in - %v", m.heldLocked())
+		m.queueLocked(false)
+		m.mu.Unlock()
+		for _, d := range rest {
+			if err := d.Release(); err != nil {
+				d.logger().Println("error on releasing lock after quorum loss :", err)
+			}
+		}
+		return
+	}
+	m.mu.Unlock()
+}
+
+// queueLocked queues a quorum signal for `deliver`, m.mu must be held
+func (m *MultiDCLock) queueLocked(acquired bool) {
+	m.signals = append(m.signals, acquired)
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends the queued quorum signals in order until ctx is done
+func (m *MultiDCLock) deliver(ctx context.Context, acquired chan<- bool, released chan<- bool) {
+	for {
+		select {
+		case <-m.wake:
+		case <-ctx.Done():
+			return
+		}
+		for {
+			m.mu.Lock()
+			if len(m.signals) == 0 {
+				m.mu.Unlock()
+				break
+			}
+			signal := m.signals[0]
+			m.signals = m.signals[1:]
+			m.mu.Unlock()
+			ch := released
+			if signal {
+				ch = acquired
+			}
+			select {
+			case ch <- true:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// hold waits for the release of the lock of d, signalled on rel. while no quorum is reached the lock is
+// released after `MinorityTimeout` plus jitter, hold then returns true
+func (m *MultiDCLock) hold(dc string, d *Dlock, rel <-chan bool) (yielded bool) {
+	for {
+		t := time.NewTimer(m.MinorityTimeout + jitter(m.MinorityTimeout))
+		select {
+		case <-rel:
+			t.Stop()
+			return false
+		case <-t.C:
+		}
+		if m.IsLeader() {
+			continue
+		}
+		d.logger().Printf("no quorum of datacenters within %s, yielding the lock of datacenter - %s", m.MinorityTimeout, dc)
+		if err := d.Release(); err != nil {
+			d.logger().Println("error on yielding lock without quorum :", err)
+		}
+		<-rel
+		return true
+	}
+}
+
+// jitter returns a random duration up to max
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+// sleepCtx waits for delay, returns false if ctx is done meanwhile
+func sleepCtx(ctx context.Context, delay time.Duration) bool {
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// IsLeader returns true while the lock is held in a quorum of datacenters
+func (m *MultiDCLock) IsLeader() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leader
+}
+
+// Held returns the sorted datacenters in which the lock is currently held
+func (m *MultiDCLock) Held() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.heldLocked()
+}
+
+func (m *MultiDCLock) heldLocked() []string {
+	held := make([]string, 0, len(m.held))
+	for dc := range m.held {
+		held = append(held, dc)
+	}
+	sort.Strings(held)
+	return held
+}
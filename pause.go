@@ -0,0 +1,30 @@
+package dlock
+
+// Pause stops contention and releases the lock if held, without the permanent teardown of `DestroySession`
+// running retry loops wait until `Resume` is called, e.g. to drain an instance during maintenance
+func (d *Dlock) Pause() error {
+	d.mu.Lock()
+	d.paused = true
+	d.mu.Unlock()
+	d.logger().Println("contention paused")
+	return d.Release()
+}
+
+// Resume restarts contention paused by `Pause`
+func (d *Dlock) Resume() {
+	d.mu.Lock()
+	d.paused = false
+	d.mu.Unlock()
+	d.logger().Println("contention resumed")
+	select {
+	case d.configCh <- struct{}{}:
+	default:
+	}
+}
+
+// Paused returns true between `Pause` and `Resume`
+func (d *Dlock) Paused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused
+}
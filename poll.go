@@ -0,0 +1,90 @@
+package dlock
+
+import (
+	"errors"
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// ErrNotSynchronous is returned by `Poll` when `SynchronousMode` is not set
+var ErrNotSynchronous = errors.New("dlock: not in synchronous mode")
+
+// polledTenure is a lock held in `SynchronousMode`, its release is handled by `Poll`
+type polledTenure struct {
+	key       string
+	sessionID string
+	lock      *api.Lock
+	resp      <-chan struct{}
+	finish    func(lock *api.Lock, sessionID string)
+}
+
+// Poll drives a Dlock in `SynchronousMode` one step: the session is renewed once and, if the lock
+// was held, it is checked whether it is still held. A lost or voluntarily released lock is then
+// handled as the release watcher does otherwise, its release signalled before Poll returns
+// lets tests assert every transition without goroutines or timing
+func (d *Dlock) Poll() error {
+	if !d.SynchronousMode {
+		return ErrNotSynchronous
+	}
+	d.mu.Lock()
+	p, sessionID := d.polled, d.SessionID
+	d.mu.Unlock()
+	// session destroyed meanwhile
+	lost := sessionID == ""
+	if !lost {
+		entry, _, err := d.ConsulClient.Session().Renew(sessionID, nil)
+		if err != nil {
+			d.renewFailures.Add(1)
+			return err
+		}
+		lost = entry == nil
+		if lost {
+			d.renewFailures.Add(1)
+			d.logger().Printf("consul session - %s is invalid now", sessionID)
+			d.clearSessionID(sessionID)
+			d.sessionInvalidated()
+		} else {
+			d.countRenewal(entry)
+			ttl := d.sessionTTL()
+			if t, err := time.ParseDuration(entry.TTL); err == nil && t > 0 {
+				ttl = t
+			}
+			d.recordRenewal(sessionID, time.Now(), ttl)
+		}
+	}
+	if p == nil {
+		return nil
+	}
+	if !lost {
+		select {
+		case <-p.resp:
+			if h := d.takeHandover(p.lock); h != nil && h.lock != nil {
+				// lock moved to a new session by SetSessionTTL, which completed the move before returning
+				p.lock, p.resp, p.sessionID = h.lock, h.resp, h.sessionID
+				return nil
+			}
+			lost = true
+		default:
+		}
+	}
+	if !lost {
+		pair, _, err := d.ConsulClient.KV().Get(p.key, nil)
+		if err != nil {
+			return err
+		}
+		lost = pair == nil || pair.Session != sessionID
+	}
+	if !lost {
+		return nil
+	}
+	d.mu.Lock()
+	if d.polled != p {
+		d.mu.Unlock()
+		return nil
+	}
+	d.polled = nil
+	d.mu.Unlock()
+	p.finish(p.lock, p.sessionID)
+	return nil
+}
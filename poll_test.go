@@ -0,0 +1,63 @@
+package dlock
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sameervitian/dlock/internal/fakeconsul"
+)
+
+func TestPollDrivesTenure(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	var renewals atomic.Int32
+	srv.AfterRequest(func(r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/session/renew/") {
+			renewals.Add(1)
+		}
+	})
+	d := newTestDlock(t, srv, "test/poll", Config{SynchronousMode: true})
+	defer d.DestroySession()
+	acquired, released, err := d.TryAcquireN(context.Background(), nil, 1, ConstantBackoff(0))
+	if !acquired || err != nil {
+		t.Fatalf("TryAcquireN = %v, %v", acquired, err)
+	}
+	if n := renewals.Load(); n != 0 {
+		t.Fatalf("session renewed %d times without Poll", n)
+	}
+
+	if err := d.Poll(); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if n := renewals.Load(); n != 1 {
+		t.Errorf("session renewed %d times by a single Poll", n)
+	}
+	if !d.IsLeader() {
+		t.Fatal("lock lost on renewal")
+	}
+	select {
+	case <-released:
+		t.Fatal("release signalled while held")
+	default:
+	}
+
+	srv.ExpireSession(d.sessionID())
+	if err := d.Poll(); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	// the release is signalled before Poll returns
+	select {
+	case <-released:
+	default:
+		t.Fatal("release not signalled by Poll after the session expired")
+	}
+	if d.IsLeader() {
+		t.Error("still leader after the session expired")
+	}
+	if got := d.Status().LastRelease; got != ReleaseLost {
+		t.Errorf("last release = %q, want %q", got, ReleaseLost)
+	}
+}
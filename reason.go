@@ -0,0 +1,33 @@
+package dlock
+
+// ReleaseReason tells why the lock was last released
+type ReleaseReason int
+
+const (
+	// ReleaseNone means the lock was never released
+	ReleaseNone ReleaseReason = iota
+	// ReleaseVoluntary is a release requested through `Release`
+	ReleaseVoluntary
+	// ReleaseLost is a release not requested by this instance e.g. session invalidation or failed health check
+	ReleaseLost
+	// ReleaseDestroyed is a release caused by `DestroySession`
+	ReleaseDestroyed
+	// ReleaseKeyDeleted is a release caused by the deletion of the lock key out of band e.g. by an operator
+	ReleaseKeyDeleted
+)
+
+func (r ReleaseReason) String() string {
+	switch r {
+	case ReleaseNone:
+		return "none"
+	case ReleaseVoluntary:
+		return "voluntary"
+	case ReleaseLost:
+		return "lost"
+	case ReleaseDestroyed:
+		return "destroyed"
+	case ReleaseKeyDeleted:
+		return "key deleted"
+	}
+	return "unknown"
+}
@@ -0,0 +1,253 @@
+package dlock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// renewLateFraction of the ttl is how late a renewal can fire before the session is verified
+const renewLateFraction = 4
+
+// ttlRecheckEvery is how many renewals pass between reads of the session ttl through Session().Info
+// so that a ttl changed out of band, e.g. by an operator, adjusts the renewal cadence
+const ttlRecheckEvery = 5
+
+// DefaultRenewFactor is the fraction of the ttl after which the session is renewed
+const DefaultRenewFactor = 0.5
+
+// afterFunc is time.After, tests replace it to fire renewals late
+type afterFunc func(time.Duration) <-chan time.Time
+
+// ErrRenewalDelayed is returned when the session could not be renewed within its ttl
+var ErrRenewalDelayed = errors.New("dlock: session renewal delayed beyond ttl")
+
+// startRenewal renews sessionID in background unless it is already being renewed
+// if renewal fails the held lock is released rather than held under a dead session
+// sessions gated on `TTLCheckID` have no ttl and are not renewed, nor are those renewed externally or through `Poll`
+func (d *Dlock) startRenewal(sessionID string, ttl time.Duration) {
+	if d.TTLCheckID != "" || d.ExternalRenewal || d.SynchronousMode {
+		return
+	}
+	d.mu.Lock()
+	if d.renewDone != nil && d.renewSession == sessionID {
+		d.mu.Unlock()
+		return
+	}
+	doneCh := make(chan struct{})
+	d.renewSession, d.renewDone = sessionID, doneCh
+	d.mu.Unlock()
+	if d.Renewer != nil {
+		d.Renewer.add(sessionID, &renewal{
+			ttl:    ttl,
+			factor: d.RenewFactor,
+			onRenew: func(at time.Time, ttl time.Duration, entry *api.SessionEntry) {
+				d.countRenewal(entry)
+				d.recordRenewal(sessionID, at, ttl)
+			},
+			onFailed: func(err error) {
+				d.renewalStopped(sessionID, doneCh, err)
+			},
+		})
+		return
+	}
+	go func() {
+		err := d.renewPeriodic(sessionID, ttl, doneCh)
+		d.renewalStopped(sessionID, doneCh, err)
+	}()
+}
+
+// renewalStopped handles the end of the renewal of sessionID started with doneCh
+// if renewal failed the held lock is released rather than held under a dead session
+func (d *Dlock) renewalStopped(sessionID string, doneCh chan struct{}, err error) {
+	d.mu.Lock()
+	if d.renewDone == doneCh {
+		d.renewSession, d.renewDone = "", nil
+	}
+	var lock *api.Lock
+	if d.SessionID == sessionID {
+		lock = d.lock
+	}
+	d.mu.Unlock()
+	if err == nil {
+		return
+	}
+	d.logger().Println("session renewal stopped :", err)
+	d.emit(Event{Type: EventRenewalFailed, Key: d.Key, SessionID: sessionID, Err: err}, false)
+	if lock == nil {
+		return
+	}
+	d.logger().Println("releasing lock held under session -", sessionID)
+	if err := lock.Unlock(); err != nil && err != api.ErrLockNotHeld {
+		d.logger().Println("error on unlock after renewal failure :", err)
+	}
+}
+
+// stopRenewal stops renewing sessionID, which destroys the session
+func (d *Dlock) stopRenewal(sessionID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.renewDone == nil || d.renewSession != sessionID {
+		return
+	}
+	close(d.renewDone)
+	d.renewSession, d.renewDone = "", nil
+	// stopping the renewal destroys the session, it is forgotten so the next attempt
+	// creates a new one instead of taking our own destroy for an invalidation
+	if d.SessionID == sessionID {
+		d.setSessionIDLocked("")
+	}
+	if d.Renewer != nil {
+		d.Renewer.remove(sessionID)
+	}
+}
+
+// RenewalStats counts the session renewals of a Dlock
+type RenewalStats struct {
+	Renewals uint64 // successful renewals
+	Failures uint64 // renewals which failed with an error
+	Bytes    uint64 // approximate payload bytes of the renewal responses
+}
+
+// RenewalStats returns the renewal counters of this Dlock, e.g. to watch the aggregate renewal traffic of a fleet
+// the traffic can be lowered through `RenewFactor` at the cost of slower detection of a stuck renewal
+func (d *Dlock) RenewalStats() RenewalStats {
+	return RenewalStats{
+		Renewals: d.renewals.Load(),
+		Failures: d.renewFailures.Load(),
+		Bytes:    d.renewBytes.Load(),
+	}
+}
+
+// renewInterval returns the wait between renewals of a session with ttl
+func (d *Dlock) renewInterval(ttl time.Duration) time.Duration {
+	return time.Duration(float64(ttl) * d.RenewFactor)
+}
+
+// renewPeriodic renews the session every `RenewFactor` of its ttl until doneCh is closed, then destroys the session
+// it mirrors api.Session.RenewPeriodic but records every successful renewal for `TimeUntilExpiry`
+// returns once the session is invalidated or could not be renewed within its ttl
+// timings rely on the monotonic clock so wall clock jumps (e.g. NTP corrections) do not shift renewals.
+// a renewal firing much later than scheduled (process pause, suspended VM) is followed by a session check
+func (d *Dlock) renewPeriodic(sessionID string, ttl time.Duration, doneCh <-chan struct{}) error {
+	session := d.ConsulClient.Session()
+	waitDur := d.renewInterval(ttl)
+	lastRenewTime := time.Now()
+	renewals := 0
+	var lastErr error
+	for {
+		if time.Since(lastRenewTime) > ttl {
+			if lastErr == nil {
+				lastErr = ErrRenewalDelayed
+			}
+			return lastErr
+		}
+		scheduled := time.Now()
+		select {
+		case at := <-d.renewAfter(waitDur):
+			late := at.Sub(scheduled) - waitDur
+			_, span := d.startSpan(context.Background(), SpanRenew, d.Key, sessionID)
+			entry, _, err := session.Renew(sessionID, nil)
+			endSpan(span, err)
+			if err != nil {
+				d.renewFailures.Add(1)
+				waitDur = time.Second
+				lastErr = err
+				continue
+			}
+			if entry == nil {
+				d.renewFailures.Add(1)
+				return ErrSessionInvalid
+			}
+			d.countRenewal(entry)
+			// server may have updated the ttl
+			if t, err := time.ParseDuration(entry.TTL); err == nil && t > 0 {
+				ttl = t
+			}
+			renewals++
+			if late > ttl/renewLateFraction || renewals%ttlRecheckEvery == 0 {
+				if late > ttl/renewLateFraction {
+					d.logger().Printf("session renewal delayed by %s, verifying session - %s", late, sessionID)
+				}
+				info, _, err := session.Info(sessionID, nil)
+				if err == nil && info == nil {
+					return ErrSessionInvalid
+				}
+				if info != nil {
+					ttl = d.checkedTTL(sessionID, info, ttl)
+				}
+			}
+			waitDur = d.renewInterval(ttl)
+			lastRenewTime = time.Now()
+			d.recordRenewal(sessionID, lastRenewTime, ttl)
+		case <-doneCh:
+			if _, err := session.Destroy(sessionID, nil); err != nil {
+				d.logger().Println("error on destroying session after release :", err)
+			}
+			return nil
+		}
+	}
+}
+
+// checkedTTL returns the ttl of the session in info, ttl if it cannot be parsed
+func (d *Dlock) checkedTTL(sessionID string, info *api.SessionEntry, ttl time.Duration) time.Duration {
+	t, err := time.ParseDuration(info.TTL)
+	if err != nil || t <= 0 || t == ttl {
+		return ttl
+	}
+	d.logger().Printf("ttl of session - %s changed from %s to %s, adjusting renewals", sessionID, ttl, t)
+	return t
+}
+
+// countRenewal adds a successful renewal which returned entry to the renewal counters
+func (d *Dlock) countRenewal(entry *api.SessionEntry) {
+	d.renewals.Add(1)
+	if b, err := json.Marshal(entry); err == nil {
+		d.renewBytes.Add(uint64(len(b)))
+	}
+}
+
+// recordRenewal notes that sessionID was valid for ttl from at
+func (d *Dlock) recordRenewal(sessionID string, at time.Time, ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.SessionID != sessionID {
+		return
+	}
+	d.renewedAt = at
+	d.renewedTTL = ttl
+	if d.IncludeSessionExpiry && d.lock != nil {
+		go d.refreshExpiry(sessionID, at, ttl)
+	}
+}
+
+// TimeUntilExpiry returns how long the current session has before it expires if renewals stopped now
+// computed from the last successful renewal (or creation) and the TTL reported by consul
+// a stuck renewal shows up as a value shrinking towards zero. ErrSessionInvalid is returned when there is no valid session
+func (d *Dlock) TimeUntilExpiry() (time.Duration, error) {
+	sessionID := d.sessionID()
+	if sessionID == "" {
+		return 0, ErrSessionInvalid
+	}
+	entry, _, err := d.ConsulClient.Session().Info(sessionID, nil)
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		return 0, ErrSessionInvalid
+	}
+	d.mu.Lock()
+	renewedAt, ttl := d.renewedAt, d.renewedTTL
+	d.mu.Unlock()
+	if t, err := time.ParseDuration(entry.TTL); err == nil && t > 0 {
+		ttl = t
+	}
+	left := ttl - time.Since(renewedAt)
+	if left < 0 {
+		left = 0
+	}
+	return left, nil
+}
@@ -0,0 +1,95 @@
+package dlock
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sameervitian/dlock/internal/fakeconsul"
+)
+
+func TestLateRenewalVerifiesSession(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	infos := make(chan struct{}, 1)
+	srv.AfterRequest(func(r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/session/info/") {
+			select {
+			case infos <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	d := newTestDlock(t, srv, "test/late-renewal", Config{})
+	ttl := 10 * time.Second
+	// the first renewal fires well past the point where the session is verified
+	first := true
+	d.renewAfter = func(wait time.Duration) <-chan time.Time {
+		if !first {
+			return time.After(wait)
+		}
+		first = false
+		ch := make(chan time.Time, 1)
+		ch <- time.Now().Add(wait + ttl/renewLateFraction + time.Second)
+		return ch
+	}
+	sessionID, err := d.createSession(context.Background())
+	if err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+	doneCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.renewPeriodic(sessionID, ttl, doneCh)
+	}()
+
+	select {
+	case <-infos:
+	case err := <-errCh:
+		t.Fatalf("renewal stopped before verifying the session: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("late renewal did not verify the session")
+	}
+	close(doneCh)
+	if err := <-errCh; err != nil {
+		t.Errorf("renewal returned %v after done", err)
+	}
+}
+
+func TestRenewalKeepsSessionTTLAfterUpdateConfig(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	d := newTestDlock(t, srv, "test/update-ttl", Config{SessionTTL: 10 * time.Second})
+	defer d.DestroySession()
+	waits := make(chan time.Duration, 1)
+	d.renewAfter = func(wait time.Duration) <-chan time.Time {
+		select {
+		case waits <- wait:
+		default:
+		}
+		return time.After(wait)
+	}
+	if err := d.recreateSession(context.Background()); err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+	// the raised ttl applies to the next session only, the current one keeps expiring after 10s
+	if err := d.UpdateConfig(&Config{SessionTTL: time.Minute}); err != nil {
+		t.Fatalf("updating config: %v", err)
+	}
+	acquired, released := make(chan bool, 1), make(chan bool, 1)
+	go d.RetryLockAcquire(nil, acquired, released)
+	if !receive(acquired, 5*time.Second) {
+		t.Fatal("lock not acquired")
+	}
+	select {
+	case wait := <-waits:
+		if wait >= 10*time.Second {
+			t.Errorf("renewal scheduled after %s, past the 10s ttl of the session", wait)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("renewal not scheduled")
+	}
+}
@@ -0,0 +1,198 @@
+package dlock
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// Renewer renews the sessions of many Dlocks from a single goroutine, set through `Config.Renewer`
+// it saves a goroutine and timer per held lock when a process holds many locks
+type Renewer struct {
+	Logger *log.Logger // logger of session destruction errors, defaults to the package logger. set before use
+
+	client   *api.Client
+	mu       sync.Mutex
+	sessions map[string]*renewal
+	wake     chan struct{}
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// renewal is a session renewed by a Renewer
+type renewal struct {
+	ttl      time.Duration
+	factor   float64
+	next     time.Time // next renewal is due
+	renewed  time.Time // last successful renewal
+	count    int       // successful renewals
+	onRenew  func(at time.Time, ttl time.Duration, entry *api.SessionEntry)
+	onFailed func(err error) // called once the session is given up
+}
+
+// NewRenewer returns a Renewer for sessions of client and starts its goroutine
+func NewRenewer(client *api.Client) *Renewer {
+	r := &Renewer{client: client, sessions: map[string]*renewal{}, wake: make(chan struct{}, 1), stopCh: make(chan struct{})}
+	go r.run()
+	return r
+}
+
+// logger returns the logger of this Renewer, the package logger unless `Logger` is set
+func (r *Renewer) logger() *log.Logger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+	return logger
+}
+
+// Stop stops the goroutine of r. sessions still registered are not renewed anymore and expire after their ttl
+func (r *Renewer) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+}
+
+// add registers sessionID for renewal every factor of its ttl
+func (r *Renewer) add(sessionID string, s *renewal) {
+	now := time.Now()
+	s.renewed = now
+	s.next = now.Add(time.Duration(float64(s.ttl) * s.factor))
+	r.mu.Lock()
+	r.sessions[sessionID] = s
+	r.mu.Unlock()
+	r.signal()
+}
+
+// remove stops renewing sessionID and destroys the session
+func (r *Renewer) remove(sessionID string) {
+	r.mu.Lock()
+	_, ok := r.sessions[sessionID]
+	delete(r.sessions, sessionID)
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	r.signal()
+	go func() {
+		if _, err := r.client.Session().Destroy(sessionID, nil); err != nil {
+			r.logger().Println("error on destroying session after release :", err)
+		}
+	}()
+}
+
+func (r *Renewer) signal() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Renewer) run() {
+	t := time.NewTimer(time.Hour)
+	defer t.Stop()
+	for {
+		if !t.Stop() {
+			select {
+			case <-t.C:
+			default:
+			}
+		}
+		t.Reset(r.untilNext())
+		select {
+		case <-t.C:
+			r.renewDue()
+		case <-r.wake:
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// untilNext returns the wait until the next renewal is due
+func (r *Renewer) untilNext() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wait := time.Hour
+	for _, s := range r.sessions {
+		if d := time.Until(s.next); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// renewDue renews every session which is due, sessions which cannot be renewed within their ttl are given up
+func (r *Renewer) renewDue() {
+	now := time.Now()
+	r.mu.Lock()
+	due := map[string]*renewal{}
+	for id, s := range r.sessions {
+		if !s.next.After(now) {
+			due[id] = s
+		}
+	}
+	r.mu.Unlock()
+	for id, s := range due {
+		err := r.renew(id, s)
+		if err == nil {
+			continue
+		}
+		r.mu.Lock()
+		_, ok := r.sessions[id]
+		delete(r.sessions, id)
+		r.mu.Unlock()
+		if ok {
+			s.onFailed(err)
+		}
+	}
+}
+
+// renew renews a single session, returns an error once the session is to be given up
+func (r *Renewer) renew(sessionID string, s *renewal) error {
+	entry, _, err := r.client.Session().Renew(sessionID, nil)
+	now := time.Now()
+	r.mu.Lock()
+	if err != nil {
+		defer r.mu.Unlock()
+		if now.Sub(s.renewed) > s.ttl {
+			return err
+		}
+		s.next = now.Add(time.Second)
+		return nil
+	}
+	if entry == nil {
+		r.mu.Unlock()
+		return ErrSessionInvalid
+	}
+	if t, err := time.ParseDuration(entry.TTL); err == nil && t > 0 {
+		s.ttl = t
+	}
+	s.count++
+	if s.count%ttlRecheckEvery == 0 {
+		r.mu.Unlock()
+		info, _, err := r.client.Session().Info(sessionID, nil)
+		if err == nil && info == nil {
+			return ErrSessionInvalid
+		}
+		r.mu.Lock()
+		if info != nil {
+			if t, err := time.ParseDuration(info.TTL); err == nil && t > 0 {
+				s.ttl = t
+			}
+		}
+	}
+	s.renewed = now
+	s.next = now.Add(time.Duration(float64(s.ttl) * s.factor))
+	ttl := s.ttl
+	r.mu.Unlock()
+	// called without r.mu as it takes the lock of the Dlock
+	if s.onRenew != nil {
+		s.onRenew(now, ttl, entry)
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package dlock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+const (
+	// rwReadersPrefix is appended to the prefix of a RWLock, shared holders register under it
+	rwReadersPrefix = "/readers/"
+	// rwWriterKey is appended to the prefix of a RWLock, the exclusive holder or the one waiting for readers to drain holds it
+	rwWriterKey = "/writer"
+)
+
+// ErrRWLockHeld is returned when a RWLock is acquired while already held in either mode
+var ErrRWLockHeld = errors.New("dlock: rwlock already held")
+
+// RWLockConfig is used to configure a RWLock
+type RWLockConfig struct {
+	Prefix        string        // KV prefix of the lock
+	SessionTTL    time.Duration // ttl of the session of this holder. defaults to DefautSessionTTL
+	RetryInterval time.Duration // wait between checks while the lock is unavailable. defaults to 1s
+	Logger        *log.Logger   // logger of this lock. defaults to the package logger set through SetLogger/SetLoggerWriter
+}
+
+// RWLock is a shared/exclusive lock over consul KV and sessions
+// any number of shared holders proceed together while an exclusive holder is alone. an exclusive
+// contender first takes the write-intent key, which keeps new shared holders out, then waits for the
+// shared holders to release, so writers are not starved by a steady flow of readers
+type RWLock struct {
+	ConsulClient  *api.Client
+	Prefix        string
+	SessionTTL    time.Duration
+	RetryInterval time.Duration
+	SessionID     string
+	Logger        *log.Logger
+
+	mu        sync.Mutex
+	heldKey   string        // key held in the current mode, empty when not held
+	exclusive bool          // mode of `heldKey`
+	acquiring bool          // an acquisition is in progress
+	doneCh    chan struct{} // stops the session renewal
+}
+
+// NewRWLock returns a new RWLock under prefix
+func NewRWLock(client *api.Client, o *RWLockConfig) (*RWLock, error) {
+	l := &RWLock{ConsulClient: client, Prefix: o.Prefix, SessionTTL: DefautSessionTTL, RetryInterval: time.Second, Logger: o.Logger}
+	if o.SessionTTL != 0 {
+		l.SessionTTL = o.SessionTTL
+	}
+	if o.RetryInterval != 0 {
+		l.RetryInterval = o.RetryInterval
+	}
+	return l, nil
+}
+
+// logger returns the logger of this RWLock, the package logger unless `Logger` is set
+func (l *RWLock) logger() *log.Logger {
+	if l.Logger != nil {
+		return l.Logger
+	}
+	return logger
+}
+
+// AcquireShared blocks until the lock is held in shared mode, alongside other shared holders
+// it waits while an exclusive holder or contender holds the write-intent key. ctx.Err() is returned once ctx is done
+func (l *RWLock) AcquireShared(ctx context.Context, value map[string]string) error {
+	if err := l.startAcquire(); err != nil {
+		return err
+	}
+	defer l.endAcquire()
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	kv := l.ConsulClient.KV()
+	for {
+		sessionID, err := l.session()
+		if err != nil {
+			return err
+		}
+		writer, err := l.writerHeld(ctx)
+		if err != nil {
+			return err
+		}
+		if !writer {
+			key := l.Prefix + rwReadersPrefix + sessionID
+			ok, _, err := kv.Acquire(&api.KVPair{Key: key, Value: b, Session: sessionID}, (&api.WriteOptions{}).WithContext(ctx))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				// session got invalidated, a new one is created on next attempt
+				l.clearSession(sessionID)
+				continue
+			}
+			// an exclusive contender may have taken the write-intent key meanwhile, it checks the readers only afterwards
+			writer, err = l.writerHeld(ctx)
+			if err != nil {
+				l.deleteKey(key)
+				return err
+			}
+			if !writer && l.setHeld(sessionID, key, false) {
+				return nil
+			}
+			// yield to the exclusive contender, or the session was lost meanwhile
+			l.deleteKey(key)
+		}
+		if !l.wait(ctx) {
+			return ctx.Err()
+		}
+	}
+}
+
+// AcquireExclusive blocks until the lock is held in exclusive mode, with no other holder
+// the write-intent key is taken first and held while the shared holders drain. ctx.Err() is returned once ctx is done
+func (l *RWLock) AcquireExclusive(ctx context.Context, value map[string]string) error {
+	if err := l.startAcquire(); err != nil {
+		return err
+	}
+	defer l.endAcquire()
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	kv := l.ConsulClient.KV()
+	key := l.Prefix + rwWriterKey
+	var sessionID string
+	for {
+		sessionID, err = l.session()
+		if err != nil {
+			return err
+		}
+		ok, _, err := kv.Acquire(&api.KVPair{Key: key, Value: b, Session: sessionID, Flags: api.LockFlagValue}, (&api.WriteOptions{}).WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+		if !l.wait(ctx) {
+			return ctx.Err()
+		}
+	}
+	for {
+		readers, err := l.readers(ctx)
+		if err == nil && readers == 0 {
+			if l.setHeld(sessionID, key, true) {
+				return nil
+			}
+			// the session was lost meanwhile, so is the write-intent key
+			return ErrSessionInvalid
+		}
+		if err == nil && !l.wait(ctx) {
+			err = ctx.Err()
+		}
+		if err != nil {
+			if _, _, rerr := kv.Release(&api.KVPair{Key: key, Session: sessionID, Flags: api.LockFlagValue}, nil); rerr != nil {
+				l.logger().Println("error on releasing rwlock write intent :", rerr)
+			}
+			return err
+		}
+	}
+}
+
+// startAcquire marks an acquisition in progress, ErrRWLockHeld is returned if the lock is held or being acquired
+// l.mu is not held while acquiring so `Held` and `Release` do not wait on the blocking attempts
+func (l *RWLock) startAcquire() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.heldKey != "" || l.acquiring {
+		return ErrRWLockHeld
+	}
+	l.acquiring = true
+	return nil
+}
+
+func (l *RWLock) endAcquire() {
+	l.mu.Lock()
+	l.acquiring = false
+	l.mu.Unlock()
+}
+
+// session returns the session of this holder, created if there is none
+func (l *RWLock) session() (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.ensureSession(); err != nil {
+		return "", err
+	}
+	return l.SessionID, nil
+}
+
+// clearSession forgets sessionID if it is still the session of this holder
+func (l *RWLock) clearSession(sessionID string) {
+	l.mu.Lock()
+	if l.SessionID == sessionID {
+		l.SessionID = ""
+	}
+	l.mu.Unlock()
+}
+
+// setHeld records key as held in the given mode, false if sessionID is no longer the session of this holder
+func (l *RWLock) setHeld(sessionID string, key string, exclusive bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.SessionID != sessionID {
+		return false
+	}
+	l.heldKey, l.exclusive = key, exclusive
+	return true
+}
+
+// Release gives up the lock in whichever mode it is held and destroys the session
+func (l *RWLock) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.SessionID == "" {
+		l.heldKey = ""
+		return nil
+	}
+	if l.heldKey != "" {
+		if l.exclusive {
+			if _, _, err := l.ConsulClient.KV().Release(&api.KVPair{Key: l.heldKey, Session: l.SessionID, Flags: api.LockFlagValue}, nil); err != nil {
+				return err
+			}
+		} else if _, err := l.ConsulClient.KV().Delete(l.heldKey, nil); err != nil {
+			return err
+		}
+		l.heldKey = ""
+	}
+	close(l.doneCh) // renewal destroys the session
+	l.SessionID = ""
+	return nil
+}
+
+// Held returns whether the lock is held and in which mode
+func (l *RWLock) Held() (held bool, exclusive bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.heldKey != "", l.exclusive
+}
+
+// writerHeld returns whether the write-intent key is held by a session
+func (l *RWLock) writerHeld(ctx context.Context) (bool, error) {
+	pair, _, err := l.ConsulClient.KV().Get(l.Prefix+rwWriterKey, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	return pair != nil && pair.Session != "", nil
+}
+
+// readers returns the number of shared holders, keys whose session is gone are not counted
+func (l *RWLock) readers(ctx context.Context) (int, error) {
+	pairs, _, err := l.ConsulClient.KV().List(l.Prefix+rwReadersPrefix, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, p := range pairs {
+		if p.Session != "" {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (l *RWLock) deleteKey(key string) {
+	if _, err := l.ConsulClient.KV().Delete(key, nil); err != nil {
+		l.logger().Println("error on deleting rwlock key :", err)
+	}
+}
+
+// wait blocks for `RetryInterval`, returns false if ctx is done meanwhile
+func (l *RWLock) wait(ctx context.Context) bool {
+	t := time.NewTimer(l.RetryInterval)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *RWLock) ensureSession() error {
+	if l.SessionID != "" {
+		return nil
+	}
+	sessionID, doneCh, err := startSession(l.ConsulClient, l.Prefix, l.SessionTTL, l.sessionLost)
+	if err != nil {
+		return err
+	}
+	l.SessionID, l.doneCh = sessionID, doneCh
+	return nil
+}
+
+// sessionLost gives up the lock once sessionID is lost, its keys are gone along with the session
+func (l *RWLock) sessionLost(sessionID string, err error) {
+	l.logger().Printf("rwlock session - %s lost : %s", sessionID, err)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.SessionID != sessionID {
+		return
+	}
+	l.SessionID = ""
+	l.heldKey = ""
+}
@@ -0,0 +1,74 @@
+package dlock
+
+import "time"
+
+// Window is a daily time window [Start, End) given as offsets from midnight in Location
+// a window with End before Start spans midnight e.g. 22h to 6h. nil Location is time.Local
+type Window struct {
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// untilEnd returns how long the window containing t stays open, false if t is outside the window
+func (w Window) untilEnd(t time.Time) (time.Duration, bool) {
+	loc := w.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	offset := t.Sub(midnight)
+	switch {
+	case w.Start <= w.End && offset >= w.Start && offset < w.End:
+		return w.End - offset, true
+	case w.Start > w.End && offset >= w.Start:
+		return 24*time.Hour - offset + w.End, true
+	case w.Start > w.End && offset < w.End:
+		return w.End - offset, true
+	}
+	return 0, false
+}
+
+// scheduleOpen returns whether t is within `LeadershipSchedule` and for how long it stays open
+// an empty schedule is always open
+func (d *Dlock) scheduleOpen(t time.Time) (time.Duration, bool) {
+	if len(d.LeadershipSchedule) == 0 {
+		return 0, true
+	}
+	open, ok := time.Duration(0), false
+	for _, w := range d.LeadershipSchedule {
+		if left, in := w.untilEnd(t); in && left > open {
+			open, ok = left, true
+		}
+	}
+	return open, ok
+}
+
+// releaseAtScheduleEnd voluntarily releases the lock acquired in epoch once `LeadershipSchedule` closes
+func (d *Dlock) releaseAtScheduleEnd(epoch int, heldCh <-chan struct{}) {
+	for {
+		left, open := d.scheduleOpen(time.Now())
+		if !open {
+			break
+		}
+		// windows may overlap, so the schedule is checked again once the current one ends
+		t := time.NewTimer(left)
+		select {
+		case <-t.C:
+		case <-heldCh:
+			t.Stop()
+			return
+		}
+	}
+	d.mu.Lock()
+	held := d.lock != nil && d.epoch == epoch
+	d.mu.Unlock()
+	if !held {
+		return
+	}
+	d.logger().Println("leadership schedule closed, releasing")
+	if err := d.Release(); err != nil {
+		d.logger().Println("error on releasing lock at schedule end :", err)
+	}
+}
@@ -0,0 +1,247 @@
+package dlock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// semaphoreLockKey is the key under the semaphore prefix holding the limit and the current holders
+const semaphoreLockKey = ".lock"
+
+// casRetries bounds the attempts to update a key through check-and-set when racing with others
+const casRetries = 5
+
+// ErrSemaphoreLimitConflict is returned when the limit of an existing semaphore differs from the configured one
+var ErrSemaphoreLimitConflict = errors.New("dlock: semaphore limit conflicts with existing semaphore")
+
+// SemaphoreConfig is used to configure a weighted semaphore
+type SemaphoreConfig struct {
+	Prefix     string        // KV prefix of the semaphore, holders register under it
+	Limit      int           // maximum sum of weights of the holders
+	Weight     int           // slots taken by this holder. defaults to 1
+	SessionTTL time.Duration // ttl of the session of this holder. defaults to DefautSessionTTL
+	Logger     *log.Logger   // logger of this holder. defaults to the package logger set through SetLogger/SetLoggerWriter
+}
+
+// Semaphore is a weighted semaphore over consul KV and sessions
+// each holder declares a weight and the sum of weights of live holders never exceeds the limit
+type Semaphore struct {
+	ConsulClient *api.Client
+	Prefix       string
+	Limit        int
+	Weight       int
+	SessionTTL   time.Duration
+	SessionID    string
+	Logger       *log.Logger
+
+	mu     sync.Mutex
+	held   bool
+	doneCh chan struct{} // stops the session renewal
+}
+
+// semaphoreLock is the content of the `.lock` key
+type semaphoreLock struct {
+	Limit   int            `json:"limit"`
+	Holders map[string]int `json:"holders"` // session id to weight
+}
+
+// NewSemaphore returns a new weighted Semaphore
+func NewSemaphore(client *api.Client, o *SemaphoreConfig) (*Semaphore, error) {
+	if o.Limit <= 0 {
+		return nil, fmt.Errorf("dlock: semaphore limit must be positive, got %d", o.Limit)
+	}
+	s := &Semaphore{ConsulClient: client, Prefix: o.Prefix, Limit: o.Limit, Weight: 1, SessionTTL: DefautSessionTTL, Logger: o.Logger}
+	if o.Weight != 0 {
+		s.Weight = o.Weight
+	}
+	if s.Weight > s.Limit {
+		return nil, fmt.Errorf("dlock: semaphore weight %d exceeds limit %d", s.Weight, s.Limit)
+	}
+	if o.SessionTTL != 0 {
+		s.SessionTTL = o.SessionTTL
+	}
+	return s, nil
+}
+
+// logger returns the logger of this Semaphore, the package logger unless `Logger` is set
+func (s *Semaphore) logger() *log.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return logger
+}
+
+// TryAcquire makes a single attempt to take `Weight` slots of the semaphore
+// returns false if the live holders leave too few slots
+func (s *Semaphore) TryAcquire(value map[string]string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held {
+		return true, nil
+	}
+	if err := s.ensureSession(); err != nil {
+		return false, err
+	}
+	kv := s.ConsulClient.KV()
+	v := make(map[string]string, len(value)+1)
+	for k, j := range value {
+		v[k] = j
+	}
+	v["weight"] = strconv.Itoa(s.Weight)
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	ok, _, err := kv.Acquire(&api.KVPair{Key: s.contenderKey(), Value: b, Session: s.SessionID}, nil)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		// session got invalidated, a new one is created on next attempt
+		s.SessionID = ""
+		return false, nil
+	}
+	for i := 0; i < casRetries; i++ {
+		acquired, done, err := s.tryUpdateHolders(func(l *semaphoreLock) bool {
+			used := 0
+			for _, w := range l.Holders {
+				used += w
+			}
+			if used+s.Weight > l.Limit {
+				return false
+			}
+			l.Holders[s.SessionID] = s.Weight
+			return true
+		})
+		if err != nil {
+			return false, err
+		}
+		if done {
+			s.held = acquired
+			return acquired, nil
+		}
+	}
+	return false, nil
+}
+
+// Release gives back the slots of this holder and destroys its session
+func (s *Semaphore) Release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.SessionID == "" {
+		return nil
+	}
+	if s.held {
+		for i := 0; i < casRetries; i++ {
+			_, done, err := s.tryUpdateHolders(func(l *semaphoreLock) bool {
+				delete(l.Holders, s.SessionID)
+				return true
+			})
+			if err != nil {
+				return err
+			}
+			if done {
+				break
+			}
+		}
+		s.held = false
+	}
+	if _, err := s.ConsulClient.KV().Delete(s.contenderKey(), nil); err != nil {
+		return err
+	}
+	close(s.doneCh) // renewal destroys the session
+	s.SessionID = ""
+	return nil
+}
+
+// tryUpdateHolders reads the `.lock` key, prunes holders whose session is gone, applies update and
+// writes it back with check-and-set. done is false if the key was modified concurrently
+func (s *Semaphore) tryUpdateHolders(update func(l *semaphoreLock) bool) (applied bool, done bool, err error) {
+	kv := s.ConsulClient.KV()
+	pairs, _, err := kv.List(s.Prefix+"/", nil)
+	if err != nil {
+		return false, false, err
+	}
+	live := map[string]bool{}
+	var lockPair *api.KVPair
+	for _, p := range pairs {
+		if p.Key == s.lockKey() {
+			lockPair = p
+			continue
+		}
+		if p.Session != "" {
+			live[p.Session] = true
+		}
+	}
+	l := semaphoreLock{Limit: s.Limit, Holders: map[string]int{}}
+	var index uint64
+	if lockPair != nil {
+		if err := json.Unmarshal(lockPair.Value, &l); err != nil {
+			return false, false, err
+		}
+		if l.Limit != s.Limit {
+			return false, false, ErrSemaphoreLimitConflict
+		}
+		if l.Holders == nil {
+			l.Holders = map[string]int{}
+		}
+		index = lockPair.ModifyIndex
+	}
+	for session := range l.Holders {
+		if !live[session] {
+			delete(l.Holders, session)
+		}
+	}
+	if !update(&l) {
+		return false, true, nil
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return false, false, err
+	}
+	ok, _, err := kv.CAS(&api.KVPair{Key: s.lockKey(), Value: b, ModifyIndex: index}, nil)
+	if err != nil {
+		return false, false, err
+	}
+	return ok, ok, nil
+}
+
+func (s *Semaphore) ensureSession() error {
+	if s.SessionID != "" {
+		return nil
+	}
+	sessionID, doneCh, err := startSession(s.ConsulClient, s.Prefix, s.SessionTTL, s.sessionLost)
+	if err != nil {
+		return err
+	}
+	s.SessionID, s.doneCh = sessionID, doneCh
+	return nil
+}
+
+// sessionLost gives up the slots once sessionID is lost
+// the holder entry of a lost session is pruned by the next update of the holders
+func (s *Semaphore) sessionLost(sessionID string, err error) {
+	s.logger().Printf("semaphore session - %s lost : %s", sessionID, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.SessionID != sessionID {
+		return
+	}
+	s.SessionID = ""
+	s.held = false
+}
+
+func (s *Semaphore) contenderKey() string {
+	return s.Prefix + "/" + s.SessionID
+}
+
+func (s *Semaphore) lockKey() string {
+	return s.Prefix + "/" + semaphoreLockKey
+}
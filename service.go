@@ -0,0 +1,67 @@
+package dlock
+
+import (
+	"context"
+	"sync"
+)
+
+// service is the background `Run` managed through `Start` and `Stop`
+type service struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	err     error // returned by Run
+	gaveUp  bool  // Run returned before `Stop`, without destroying the session
+	stopped bool
+}
+
+// Start runs the whole lifecycle of the lock in background and returns immediately: contention,
+// re-contention after every release and the session, until `Stop` is called
+// Leadership transitions are delivered through `OnBecomeLeader`, `Subscribe` or `Status` set up beforehand
+// a fatal error on which contention gave up is sent to `Errors()`
+// Calls after the first one are no-ops, ErrPermanentlyReleased is returned if the Dlock was already destroyed
+func (d *Dlock) Start(value map[string]string) error {
+	if d.permanentlyReleased() {
+		return ErrPermanentlyReleased
+	}
+	d.service.mu.Lock()
+	defer d.service.mu.Unlock()
+	if d.service.done != nil || d.service.stopped {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.service.cancel = cancel
+	d.service.done = make(chan struct{})
+	go func() {
+		defer close(d.service.done)
+		err := d.Run(ctx, value)
+		if ctx.Err() == nil {
+			d.service.gaveUp = true
+			d.reportError(err)
+		}
+		d.service.err = err
+	}()
+	d.logger().Println("dlock started")
+	return nil
+}
+
+// Stop ends the lifecycle begun by `Start`: contention stops, the lock is released and the session destroyed,
+// permanently unless `ReusableOnCancel` is set. It blocks until the background `Run` returned
+// Calls after the first one, or without `Start`, are no-ops
+func (d *Dlock) Stop() error {
+	d.service.mu.Lock()
+	defer d.service.mu.Unlock()
+	if d.service.done == nil || d.service.stopped {
+		return nil
+	}
+	d.service.stopped = true
+	d.service.cancel()
+	<-d.service.done
+	d.logger().Println("dlock stopped")
+	if d.service.gaveUp {
+		// the session outlived Run
+		return d.releaseOnCancel()
+	}
+	// result of destroying the session
+	return d.service.err
+}
@@ -0,0 +1,53 @@
+package dlock
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// HandleSignals destroys the session, releasing the lock if held, as soon as one of signals is received
+// so the next leader is elected right away instead of after the session ttl. defaults to os.Interrupt and SIGTERM
+// the destruction is bounded by `ShutdownTimeout`. done is closed once it completed or timed out, the process
+// is expected to exit then. stop uninstalls the handler without destroying anything
+func (d *Dlock) HandleSignals(signals ...os.Signal) (done <-chan struct{}, stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	sigCh := make(chan os.Signal, 1)
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	signal.Notify(sigCh, signals...)
+	go func() {
+		defer signal.Stop(sigCh)
+		select {
+		case sig := <-sigCh:
+			d.logger().Println("received signal", sig, "destroying session")
+		case <-stopCh:
+			return
+		}
+		defer close(doneCh)
+		destroyed := make(chan error, 1)
+		go func() {
+			destroyed <- d.DestroySession()
+		}()
+		t := time.NewTimer(d.ShutdownTimeout)
+		defer t.Stop()
+		select {
+		case err := <-destroyed:
+			if err != nil {
+				d.logger().Println("error on destroying session on signal :", err)
+			}
+		case <-t.C:
+			d.logger().Println("session not destroyed within", d.ShutdownTimeout, "giving up")
+		}
+	}()
+	var once sync.Once
+	return doneCh, func() {
+		once.Do(func() {
+			close(stopCh)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+//go:build !windows
+
+package dlock
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sameervitian/dlock/internal/fakeconsul"
+)
+
+func TestHandleSignalsDestroysSession(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	d := newTestDlock(t, srv, "test/signals", Config{})
+	acquired, released := make(chan bool, 1), make(chan bool, 1)
+	go d.RetryLockAcquire(nil, acquired, released)
+	if !receive(acquired, 5*time.Second) {
+		t.Fatal("lock not acquired")
+	}
+	sessionID := d.sessionID()
+	done, stop := d.HandleSignals(syscall.SIGUSR1)
+	defer stop()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatalf("sending signal: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("done not closed after the signal")
+	}
+	entry, _, err := d.ConsulClient.Session().Info(sessionID, nil)
+	if err != nil {
+		t.Fatalf("reading session: %v", err)
+	}
+	if entry != nil {
+		t.Errorf("session %s still exists after the signal", sessionID)
+	}
+	if !receive(released, 5*time.Second) {
+		t.Error("release not signalled after the signal")
+	}
+}
+
+func TestHandleSignalsStop(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	d := newTestDlock(t, srv, "test/signals-stop", Config{})
+	defer d.DestroySession()
+	acquired, released := make(chan bool, 1), make(chan bool, 1)
+	go d.RetryLockAcquire(nil, acquired, released)
+	if !receive(acquired, 5*time.Second) {
+		t.Fatal("lock not acquired")
+	}
+	// keeps the signal from terminating the test once the handler is uninstalled
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1)
+	defer signal.Stop(sigCh)
+	done, stop := d.HandleSignals(syscall.SIGUSR1)
+	stop()
+	// the handler goroutine uninstalls asynchronously
+	time.Sleep(100 * time.Millisecond)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatalf("sending signal: %v", err)
+	}
+	select {
+	case <-sigCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal not delivered")
+	}
+	select {
+	case <-done:
+		t.Error("done closed after stop")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if !d.IsLeader() {
+		t.Error("lock released by a signal after stop")
+	}
+}
@@ -0,0 +1,54 @@
+package dlock
+
+import "time"
+
+// DefaultStabilityThreshold is how many session invalidations within `StabilityWindow` count as churn
+const DefaultStabilityThreshold = 3
+
+// churn keeps the recent session invalidations to detect instability e.g. during a consul upgrade
+type churn struct {
+	window    time.Duration
+	threshold int
+	events    []time.Time
+}
+
+// record notes a session invalidation at now
+func (c *churn) record(now time.Time) {
+	if c.window <= 0 {
+		return
+	}
+	c.prune(now)
+	c.events = append(c.events, now)
+}
+
+// excess returns how many invalidations within the window exceed the threshold, zero when stable
+func (c *churn) excess(now time.Time) int {
+	if c.window <= 0 {
+		return 0
+	}
+	c.prune(now)
+	if len(c.events) < c.threshold {
+		return 0
+	}
+	return len(c.events) - c.threshold + 1
+}
+
+func (c *churn) prune(now time.Time) {
+	i := 0
+	for i < len(c.events) && now.Sub(c.events[i]) > c.window {
+		i++
+	}
+	c.events = c.events[i:]
+}
+
+// backoff doubles delay for every invalidation in excess, capped at the window
+func (c *churn) backoff(delay time.Duration, now time.Time) time.Duration {
+	n := c.excess(now)
+	for i := 0; i < n && delay < c.window; i++ {
+		delay *= 2
+	}
+	if n > 0 && delay > c.window {
+		delay = c.window
+	}
+	return delay
+}
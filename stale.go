@@ -0,0 +1,74 @@
+package dlock
+
+import (
+	"context"
+	"os"
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// holderHostKey is the key of the holder value carrying the hostname of the process which wrote it
+const holderHostKey = "holderHost"
+
+// hostname of this process, written to the holder value
+var hostname, _ = os.Hostname()
+
+// StaleHolderPolicy tells how to handle the lock held by a previous process on this host, e.g. after a restart
+// the prior holder is recognised by the hostname of the holder value, a process token other than ours
+// and an acquisition time before this process started, so a live sibling process on the same host is left alone
+type StaleHolderPolicy int
+
+const (
+	// StaleHolderContend treats the prior holder as any other holder
+	StaleHolderContend StaleHolderPolicy = iota
+	// StaleHolderWaitOut skips attempts quietly until the session of the prior holder expires
+	StaleHolderWaitOut
+	// StaleHolderForceBreak destroys the session of the prior holder. the lock becomes
+	// available once the lock delay of that session has passed
+	StaleHolderForceBreak
+)
+
+func (p StaleHolderPolicy) String() string {
+	switch p {
+	case StaleHolderContend:
+		return "contend"
+	case StaleHolderWaitOut:
+		return "wait-out"
+	case StaleHolderForceBreak:
+		return "force-break"
+	}
+	return "unknown"
+}
+
+// staleOwnHolder returns true if holder was written by a previous process on this host
+// a holder acquiring after this process started is a live sibling even with another process token
+// layout is the `AcquisitionTimeFormat` of holder, a holder of unknown acquisition time is never stale
+func staleOwnHolder(holder map[string]string, layout string) bool {
+	host, ok := holder[holderHostKey]
+	if !ok || hostname == "" || host != hostname {
+		return false
+	}
+	if token, ok := holder[processTokenKey]; !ok || token == processToken {
+		return false
+	}
+	acquired, err := parseTime(holder["lockAcquisitionTime"], layout)
+	// the acquisition time may be truncated to the second, a holder within that second is not proven older
+	return err == nil && acquired.Before(processStart.Truncate(time.Second))
+}
+
+// handleStaleHolder applies `StaleHolderPolicy` to pair held by a previous process on this host
+// returns false if the attempt should be skipped
+func (d *Dlock) handleStaleHolder(ctx context.Context, pair *api.KVPair) (bool, error) {
+	switch d.StaleHolderPolicy {
+	case StaleHolderWaitOut:
+		d.logger().Printf("key - %s is held by session - %s of a previous process on this host, waiting for it to expire", pair.Key, pair.Session)
+		return false, nil
+	case StaleHolderForceBreak:
+		d.logger().Printf("key - %s is held by session - %s of a previous process on this host, destroying it", pair.Key, pair.Session)
+		if _, err := d.ConsulClient.Session().Destroy(pair.Session, (&api.WriteOptions{}).WithContext(ctx)); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
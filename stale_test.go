@@ -0,0 +1,55 @@
+package dlock
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+	"github.com/sameervitian/dlock/internal/fakeconsul"
+)
+
+func TestStaleHolderForceBreak(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		acquired time.Time
+		broken   bool
+	}{
+		{name: "previous process", acquired: processStart.Add(-time.Hour), broken: true},
+		{name: "live sibling", acquired: time.Now(), broken: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := fakeconsul.New()
+			defer srv.Close()
+			const key = "test/stale-holder"
+			d := newTestDlock(t, srv, key, Config{StaleHolderPolicy: StaleHolderForceBreak})
+			defer d.DestroySession()
+			// another process on this host holds the key
+			sessionID, _, err := d.ConsulClient.Session().Create(&api.SessionEntry{TTL: "10s", Behavior: api.SessionBehaviorDelete}, nil)
+			if err != nil {
+				t.Fatalf("creating holder session: %v", err)
+			}
+			b, _ := json.Marshal(map[string]string{
+				holderHostKey:         hostname,
+				processTokenKey:       "other",
+				"lockAcquisitionTime": formatTime(tc.acquired, ""),
+			})
+			if ok, _, err := d.ConsulClient.KV().Acquire(&api.KVPair{Key: key, Value: b, Session: sessionID}, nil); err != nil || !ok {
+				t.Fatalf("acquiring as holder = %v, %v", ok, err)
+			}
+
+			acquired, released := make(chan bool, 1), make(chan bool, 1)
+			go d.RetryLockAcquire(nil, acquired, released)
+			if got := receive(acquired, time.Second); got != tc.broken {
+				t.Errorf("acquired = %v, want %v", got, tc.broken)
+			}
+			entry, _, err := d.ConsulClient.Session().Info(sessionID, nil)
+			if err != nil {
+				t.Fatalf("reading holder session: %v", err)
+			}
+			if broken := entry == nil; broken != tc.broken {
+				t.Errorf("holder session destroyed = %v, want %v", broken, tc.broken)
+			}
+		})
+	}
+}
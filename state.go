@@ -0,0 +1,78 @@
+package dlock
+
+import "time"
+
+// subscriberBuffer is the buffer size of each subscriber chan, changes are dropped for a full chan
+const subscriberBuffer = 16
+
+// State is the lifecycle state of a Dlock
+type State int
+
+const (
+	// StateIdle means the lock is neither held nor contended for
+	StateIdle State = iota
+	// StateContending means acquisition attempts are running
+	StateContending
+	// StateLeader means the lock is held
+	StateLeader
+	// StatePermanentlyReleased means the session was destroyed, the Dlock cannot acquire anymore
+	StatePermanentlyReleased
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateContending:
+		return "contending"
+	case StateLeader:
+		return "leader"
+	case StatePermanentlyReleased:
+		return "permanently-released"
+	}
+	return "unknown"
+}
+
+// StateChange is a transition between two states delivered to subscribers
+type StateChange struct {
+	From State
+	To   State
+	Time time.Time
+}
+
+// Subscribe returns a chan receiving every state change from now on and a func to unsubscribe
+// each subscriber gets its own chan, which is closed on unsubscribe. changes are dropped while the chan is full
+func (d *Dlock) Subscribe() (<-chan StateChange, func()) {
+	ch := make(chan StateChange, subscriberBuffer)
+	d.mu.Lock()
+	if d.subscribers == nil {
+		d.subscribers = map[chan StateChange]struct{}{}
+	}
+	d.subscribers[ch] = struct{}{}
+	d.mu.Unlock()
+	unsubscribe := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if _, ok := d.subscribers[ch]; ok {
+			delete(d.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// setStateLocked moves to state s and notifies the subscribers, d.mu must be held
+// the permanently released state is final
+func (d *Dlock) setStateLocked(s State) {
+	if d.state == s || d.state == StatePermanentlyReleased {
+		return
+	}
+	c := StateChange{From: d.state, To: s, Time: time.Now()}
+	d.state = s
+	for ch := range d.subscribers {
+		select {
+		case ch <- c:
+		default:
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package dlock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// processTokenKey is the key of the holder value carrying the token of the process which wrote it
+const processTokenKey = "processToken"
+
+// processToken identifies this process in the holder values it writes
+var processToken = newProcessToken()
+
+// processStart is when this process started, holders acquired earlier under another token are stale
+var processStart = time.Now()
+
+func newProcessToken() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// checkSharedSession logs a loud warning when the lock key is held by our own session
+// but was written by another process, which means the session id is shared e.g. through a copied config
+func (d *Dlock) checkSharedSession(key string, sessionID string, holder map[string]string) {
+	token, ok := holder[processTokenKey]
+	if !ok || token == processToken {
+		return
+	}
+	d.logger().Printf("WARNING: key - %s is held by session - %s of this instance but was written by process token - %s (ours - %s). session appears to be shared between processes",
+		key, sessionID, token, processToken)
+}
@@ -0,0 +1,55 @@
+package dlock
+
+import "context"
+
+// span names of the traced lock operations
+const (
+	SpanAcquireLock    = "dlock.AcquireLock"
+	SpanCreateSession  = "dlock.CreateSession"
+	SpanDestroySession = "dlock.DestroySession"
+	SpanRenew          = "dlock.Renew"
+)
+
+// span attributes set on every span
+const (
+	AttrKey       = "dlock.key"
+	AttrSessionID = "dlock.session_id"
+)
+
+// Tracer starts spans around lock operations, see package dlockotel for OpenTelemetry
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs map[string]string) (context.Context, Span)
+}
+
+// Span is a single traced operation
+type Span interface {
+	SetAttribute(key string, value string)
+	RecordError(err error)
+	End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, string) {}
+func (noopSpan) RecordError(error)           {}
+func (noopSpan) End()                        {}
+
+// startSpan starts span name for key and sessionID, a no-op span when no `Tracer` is configured
+func (d *Dlock) startSpan(ctx context.Context, name string, key string, sessionID string) (context.Context, Span) {
+	if d.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	attrs := map[string]string{AttrKey: key}
+	if sessionID != "" {
+		attrs[AttrSessionID] = sessionID
+	}
+	return d.Tracer.Start(ctx, name, attrs)
+}
+
+// endSpan records err, if any, on span and ends it
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -0,0 +1,133 @@
+package dlock
+
+import (
+	"context"
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// handover tracks the move of a held lock from one session to another, see `SetSessionTTL`
+type handover struct {
+	from      *api.Lock
+	ready     chan struct{} // closed once the move is done or failed
+	lock      *api.Lock     // lock under the new session, nil if the move failed
+	resp      <-chan struct{}
+	sessionID string
+}
+
+// SetSessionTTL changes the TTL of the consul session at runtime, e.g. longer while in a critical section
+// consul cannot update the TTL of an existing session, so a new session is created with ttl:
+//   - lock not held: the current session is destroyed and the next attempt creates one with ttl
+//   - lock held: the lock key is moved to the new session in a single consul transaction and
+//     the old session is destroyed afterwards. The lock is never free in between and no
+//     release is signalled. On failure the lock stays with the old session
+//
+// `SessionTTL` is updated either way and applies to all later sessions
+func (d *Dlock) SetSessionTTL(ctx context.Context, ttl time.Duration) error {
+	if err := checkTiming(ttl, d.retryInterval()); err != nil {
+		return err
+	}
+	d.destroyMu.Lock()
+	defer d.destroyMu.Unlock()
+	if d.permanentlyReleased() {
+		return ErrPermanentlyReleased
+	}
+	d.mu.Lock()
+	d.SessionTTL = ttl
+	old, oldSession, key := d.lock, d.SessionID, d.heldKey
+	d.mu.Unlock()
+	if oldSession == "" {
+		return nil
+	}
+	if old == nil {
+		d.stopRenewal(oldSession)
+		if _, err := d.ConsulClient.Session().Destroy(oldSession, nil); err != nil {
+			return err
+		}
+		d.clearSessionID(oldSession)
+		d.logger().Printf("destroyed consul session - %s for new ttl - %s", oldSession, ttl)
+		return nil
+	}
+
+	pair, _, err := d.ConsulClient.KV().Get(key, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if pair == nil || pair.Session != oldSession {
+		return ErrNotAcquired
+	}
+	sessionID, err := d.createSession(ctx)
+	if err != nil {
+		return err
+	}
+	h := &handover{from: old, ready: make(chan struct{})}
+	d.mu.Lock()
+	d.handover = h
+	d.mu.Unlock()
+	defer close(h.ready)
+
+	lock, resp, err := d.moveLock(ctx, pair, oldSession, sessionID)
+	if err != nil {
+		d.mu.Lock()
+		d.handover = nil
+		d.mu.Unlock()
+		if _, derr := d.ConsulClient.Session().Destroy(sessionID, nil); derr != nil {
+			d.logger().Println("error on destroying unused session :", derr)
+		}
+		return err
+	}
+	h.lock, h.resp, h.sessionID = lock, resp, sessionID
+	d.mu.Lock()
+	d.lock = lock
+	d.setSessionIDLocked(sessionID)
+	d.renewedAt = time.Now()
+	d.renewedTTL = ttl
+	d.mu.Unlock()
+	// stopped first as startRenewal moves the renewal bookkeeping over to the new session
+	d.stopRenewal(oldSession)
+	d.startRenewal(sessionID, ttl)
+	d.logger().Printf("lock moved from session - %s to session - %s with ttl - %s", oldSession, sessionID, ttl)
+	return nil
+}
+
+// moveLock atomically hands the lock key in pair over from session from to session to
+// and returns the lock watching it under the new session
+func (d *Dlock) moveLock(ctx context.Context, pair *api.KVPair, from string, to string) (*api.Lock, <-chan struct{}, error) {
+	ops := api.TxnOps{
+		&api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVUnlock, Key: pair.Key, Value: pair.Value, Flags: pair.Flags, Session: from}},
+		&api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVLock, Key: pair.Key, Value: pair.Value, Flags: pair.Flags, Session: to}},
+	}
+	ok, _, _, err := d.ConsulClient.Txn().Txn(ops, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, ErrNotAcquired
+	}
+	lock, err := d.ConsulClient.LockOpts(d.lockOptions(pair.Key, pair.Value, to))
+	if err != nil {
+		return nil, nil, err
+	}
+	// the key is already held by the new session so this returns right away
+	resp, err := lock.Lock(ctx.Done())
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp == nil {
+		return nil, nil, ErrNotAcquired
+	}
+	return lock, resp, nil
+}
+
+// takeHandover returns the pending move of lock to a new session if any
+func (d *Dlock) takeHandover(lock *api.Lock) *handover {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h := d.handover
+	if h == nil || h.from != lock {
+		return nil
+	}
+	d.handover = nil
+	return h
+}
@@ -0,0 +1,23 @@
+package dlock
+
+import (
+	"time"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// heartbeatTTLCheck marks `TTLCheckID` passing every `TTLCheckHeartbeat` until the Dlock is permanently released
+// the session is gated on that check instead of a session ttl, so it lives as long as the check passes
+func (d *Dlock) heartbeatTTLCheck() {
+	ticker := time.NewTicker(d.TTLCheckHeartbeat)
+	defer ticker.Stop()
+	for {
+		if d.permanentlyReleased() {
+			return
+		}
+		if err := d.ConsulClient.Agent().UpdateTTL(d.TTLCheckID, "dlock heartbeat", api.HealthPassing); err != nil {
+			d.logger().Println("error on updating ttl check", d.TTLCheckID, ":", err)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,65 @@
+package dlock
+
+import (
+	"sync"
+
+	api "github.com/hashicorp/consul/api"
+)
+
+// heldValue is the holder value of a tenure, replaced by UpdateValueCAS and rewritten by the heartbeat
+// mu is held across each write of the value so the two never overwrite each other
+type heldValue struct {
+	mu     sync.Mutex
+	value  map[string]string
+	status []byte // value last mirrored to `StatusKey`
+}
+
+// UpdateValueCAS replaces the holder value of the held lock with value through check-and-set
+// `lockAcquisitionTime` and the other keys added by dlock are kept. ok is false if the key was modified
+// since it was read or is no more held by our session, which also reveals lost leadership
+// `StatusKey` is updated along on success, and later heartbeats keep the new value
+func (d *Dlock) UpdateValueCAS(value map[string]string) (ok bool, err error) {
+	d.mu.Lock()
+	held, key, sessionID, hv := d.lock != nil, d.heldKey, d.SessionID, d.heldValue
+	d.mu.Unlock()
+	if !held {
+		return false, ErrNotAcquired
+	}
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	pair, _, err := d.ConsulClient.KV().Get(key, nil)
+	if err != nil {
+		return false, err
+	}
+	if pair == nil || pair.Session != sessionID {
+		return false, nil
+	}
+	var current map[string]string
+	if err := d.Codec.Unmarshal(pair.Value, &current); err != nil {
+		return false, err
+	}
+	v := make(map[string]string, len(value)+5)
+	for k, j := range value {
+		v[k] = j
+	}
+	for _, k := range []string{"lockAcquisitionTime", processTokenKey, holderHostKey, sessionTTLKey, validUntilKey} {
+		if j, ok := current[k]; ok {
+			v[k] = j
+		}
+	}
+	b, err := d.Codec.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	ops := api.TxnOps{
+		&api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVCheckSession, Key: key, Session: sessionID}},
+		&api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: b, Flags: pair.Flags, Index: pair.ModifyIndex}},
+	}
+	ok, _, _, err = d.ConsulClient.Txn().Txn(ops, nil)
+	if err != nil || !ok {
+		return false, err
+	}
+	hv.value, hv.status = v, b
+	d.writeStatus(b)
+	return true, nil
+}
@@ -0,0 +1,54 @@
+package dlock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sameervitian/dlock/internal/fakeconsul"
+)
+
+func TestUpdateValueKeptByHeartbeat(t *testing.T) {
+	srv := fakeconsul.New()
+	defer srv.Close()
+	const key, statusKey = "test/update-heartbeat", "test/update-heartbeat-status"
+	d := newTestDlock(t, srv, key, Config{StatusKey: statusKey, HeartbeatInterval: 50 * time.Millisecond})
+	defer d.DestroySession()
+	acquired, released := make(chan bool, 1), make(chan bool, 1)
+	go d.RetryLockAcquire(map[string]string{"role": "old"}, acquired, released)
+	if !receive(acquired, 5*time.Second) {
+		t.Fatal("lock not acquired")
+	}
+	ok, err := d.UpdateValueCAS(map[string]string{"role": "new"})
+	if err != nil || !ok {
+		t.Fatalf("UpdateValueCAS = %v, %v", ok, err)
+	}
+	// let a few heartbeats rewrite the holder value
+	time.Sleep(200 * time.Millisecond)
+	holder, err := d.CurrentHolder()
+	if err != nil {
+		t.Fatalf("reading holder: %v", err)
+	}
+	if holder["role"] != "new" || holder["heartbeat"] == "" {
+		t.Errorf("holder after heartbeats = %v, want role new with a heartbeat", holder)
+	}
+	status, _, err := ReadHolder(d.ConsulClient, statusKey)
+	if err != nil {
+		t.Fatalf("reading status: %v", err)
+	}
+	if status["role"] != "new" {
+		t.Errorf("status = %v, want role new", status)
+	}
+	if err := d.Release(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if !receive(released, 5*time.Second) {
+		t.Fatal("release not signalled")
+	}
+	pair, _, err := d.ConsulClient.KV().Get(statusKey, nil)
+	if err != nil {
+		t.Fatalf("reading status: %v", err)
+	}
+	if pair != nil {
+		t.Errorf("status key left after release: %s", pair.Value)
+	}
+}
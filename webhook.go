@@ -0,0 +1,73 @@
+package dlock
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout bounds each leadership webhook POST
+const webhookTimeout = 5 * time.Second
+
+// WebhookEvent is the JSON payload POSTed to `LeadershipWebhook`
+type WebhookEvent struct {
+	Event     string            `json:"event"` // `acquired` or `released`
+	Key       string            `json:"key"`
+	SessionID string            `json:"session"`
+	Timestamp time.Time         `json:"timestamp"`
+	Holder    map[string]string `json:"holder"`
+}
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// notifyWebhook POSTs the leadership change to `LeadershipWebhook` in background
+// changes are posted one at a time in order, so the receiver never sees a release before its acquisition
+// failures are only logged, they never affect the lock
+func (d *Dlock) notifyWebhook(event string, key string, sessionID string, holder map[string]string) {
+	if d.LeadershipWebhook == "" {
+		return
+	}
+	b, err := json.Marshal(WebhookEvent{Event: event, Key: key, SessionID: sessionID, Timestamp: time.Now(), Holder: holder})
+	if err != nil {
+		d.logger().Println("error on webhook payload marshal", err)
+		return
+	}
+	d.webhookMu.Lock()
+	defer d.webhookMu.Unlock()
+	d.webhookQueue = append(d.webhookQueue, b)
+	if d.notifying {
+		return
+	}
+	d.notifying = true
+	go d.drainWebhook()
+}
+
+// drainWebhook posts the queued webhook payloads in order and returns once the queue is empty
+func (d *Dlock) drainWebhook() {
+	for {
+		d.webhookMu.Lock()
+		if len(d.webhookQueue) == 0 {
+			d.notifying = false
+			d.webhookMu.Unlock()
+			return
+		}
+		b := d.webhookQueue[0]
+		d.webhookQueue = d.webhookQueue[1:]
+		d.webhookMu.Unlock()
+		d.postWebhook(b)
+	}
+}
+
+// postWebhook POSTs a single payload
+func (d *Dlock) postWebhook(b []byte) {
+	resp, err := webhookClient.Post(d.LeadershipWebhook, "application/json", bytes.NewReader(b))
+	if err != nil {
+		d.logger().Println("error on notifying leadership webhook :", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		d.logger().Println("leadership webhook responded with status", resp.Status)
+	}
+}